@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
@@ -10,8 +11,10 @@ import (
 
 	"github.com/starline/rabbitmq-worker/internal/api"
 	"github.com/starline/rabbitmq-worker/internal/config"
+	"github.com/starline/rabbitmq-worker/internal/dlr"
 	"github.com/starline/rabbitmq-worker/internal/logging"
 	"github.com/starline/rabbitmq-worker/internal/metrics"
+	"github.com/starline/rabbitmq-worker/internal/templates"
 	"github.com/starline/rabbitmq-worker/internal/worker"
 	"github.com/sirupsen/logrus"
 )
@@ -33,21 +36,65 @@ func main() {
 		"rabbitmq_port":  cfg.RabbitMQ.Port,
 		"rabbitmq_queue": cfg.RabbitMQ.Queue,
 		"api_url":        cfg.API.URL,
+		"api_providers":  len(cfg.API.ProviderConfigs()),
 		"metrics_port":   cfg.Server.Port,
 	})
 
+	// Receive delivery reports on the metrics server
+	reports, err := dlr.OpenTracker(&cfg.DLR)
+	if err != nil {
+		logging.Error("failed to open delivery report store", err, logrus.Fields{
+			"backend": cfg.DLR.Backend,
+		})
+		logger.Exit(1)
+	}
+	defer reports.Close()
+	http.Handle(cfg.DLR.Path, dlr.NewHandler(reports, cfg.DLR.Token))
+	if cfg.DLR.Token == "" {
+		logging.Warn("delivery report token is not set, anyone who can reach the endpoint can change message statuses", logrus.Fields{
+			"dlr_path": cfg.DLR.Path,
+		})
+	}
+
 	// Start metrics server
 	metrics.StartMetricsServer(strconv.Itoa(cfg.Server.Port), cfg.Server.MetricsPath)
 	logging.Info("metrics server started", logrus.Fields{
-		"port": cfg.Server.Port,
-		"path": cfg.Server.MetricsPath,
+		"port":     cfg.Server.Port,
+		"path":     cfg.Server.MetricsPath,
+		"dlr_path": cfg.DLR.Path,
 	})
 
-	// Create API client
-	apiClient := api.NewClient(&cfg.API)
+	// Create SMS providers
+	provider, err := api.NewRouter(cfg.API.ProviderConfigs())
+	if err != nil {
+		logging.Error("failed to create SMS providers", err)
+		logger.Exit(1)
+	}
+
+	// Open deduplication store
+	dedup, err := worker.OpenDedupStore(&cfg.Dedup)
+	if err != nil {
+		logging.Error("failed to open dedup store", err, logrus.Fields{
+			"backend": cfg.Dedup.Backend,
+		})
+		logger.Exit(1)
+	}
+	defer dedup.Close()
+
+	// Load message templates
+	tmpl := templates.New(&cfg.Templates)
+	if err := tmpl.Reload(); err != nil {
+		logging.Error("failed to load message templates", err, logrus.Fields{
+			"dir": cfg.Templates.Dir,
+		})
+		logger.Exit(1)
+	}
 
 	// Create worker
-	w := worker.New(cfg, apiClient)
+	w := worker.New(cfg, provider,
+		worker.WithDedupStore(dedup),
+		worker.WithTemplates(tmpl),
+		worker.WithDeliveryTracker(reports))
 
 	// Setup graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
@@ -63,6 +110,18 @@ func main() {
 		cancel()
 	}()
 
+	// Reload message templates on SIGHUP
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+
+	go func() {
+		for range hupChan {
+			if err := tmpl.Reload(); err != nil {
+				logging.Error("failed to reload message templates, keeping the old ones", err)
+			}
+		}
+	}()
+
 	// Start worker
 	logging.Info("starting RabbitMQ worker")
 	if err := w.Start(ctx); err != nil {
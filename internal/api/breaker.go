@@ -0,0 +1,188 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/starline/rabbitmq-worker/internal/config"
+	"github.com/starline/rabbitmq-worker/internal/logging"
+	"github.com/starline/rabbitmq-worker/internal/metrics"
+)
+
+// ErrCircuitOpen is wrapped in an ErrTransient when a provider is not called
+// because its circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// Breaker is a provider that stops calling a failing provider for a while.
+//
+// While closed, requests pass through and failures are counted over a window.
+// Once the failure ratio reaches the threshold the breaker opens and requests
+// fail immediately. After the open timeout it turns half-open and lets a few
+// trial requests through: a success closes it, a failure opens it again.
+// Trials ending in a rejection or cancellation leave it half-open.
+type Breaker struct {
+	provider Provider
+	config   config.BreakerConfig
+	now      func() time.Time
+
+	mu          sync.Mutex
+	state       string
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	trials      int
+	// generation changes with every state change so that results of
+	// requests admitted in an earlier state are ignored
+	generation uint64
+}
+
+// NewBreaker wraps provider with a circuit breaker
+func NewBreaker(provider Provider, cfg config.BreakerConfig) *Breaker {
+	b := &Breaker{
+		provider: provider,
+		config:   cfg,
+		now:      time.Now,
+	}
+	b.setState(metrics.CircuitClosed)
+	return b
+}
+
+// Name identifies the provider in logs and metrics
+func (b *Breaker) Name() string {
+	return b.provider.Name()
+}
+
+// State returns the current breaker state
+func (b *Breaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance()
+	return b.state
+}
+
+// Send passes msg to the wrapped provider unless the breaker is open
+func (b *Breaker) Send(ctx context.Context, msg Message) (SendResult, error) {
+	generation, ok := b.allow()
+	if !ok {
+		return SendResult{Provider: b.Name()}, &ErrTransient{Err: ErrCircuitOpen}
+	}
+
+	result, err := b.provider.Send(ctx, msg)
+	b.record(generation, err)
+	return result, err
+}
+
+// allow reports whether a request may be sent and counts half-open trials.
+// It returns the generation the request's result is recorded against.
+func (b *Breaker) allow() (uint64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance()
+
+	switch b.state {
+	case metrics.CircuitOpen:
+		return b.generation, false
+	case metrics.CircuitHalfOpen:
+		if b.trials >= b.config.HalfOpenRequests {
+			return b.generation, false
+		}
+		b.trials++
+	}
+	return b.generation, true
+}
+
+// record updates the breaker with the outcome of a request admitted in
+// generation. Results of requests admitted before the last state change,
+// such as a slow request from before the breaker opened finishing during a
+// half-open trial, say nothing about the current state and are ignored.
+func (b *Breaker) record(generation uint64, err error) {
+	failed := isProviderFailure(err)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	switch b.state {
+	case metrics.CircuitHalfOpen:
+		b.trials--
+		switch {
+		case failed:
+			b.open()
+		case err == nil:
+			b.close()
+		}
+		// Otherwise the trial said nothing about the provider's health and
+		// another one is let through
+	case metrics.CircuitClosed:
+		b.requests++
+		if failed {
+			b.failures++
+		}
+		if b.requests >= b.config.MinRequests &&
+			float64(b.failures)/float64(b.requests) >= b.config.FailureRatio {
+			b.open()
+		}
+	}
+}
+
+// advance moves an open breaker to half-open after the open timeout and
+// starts a new counting window for a closed one
+func (b *Breaker) advance() {
+	now := b.now()
+	switch b.state {
+	case metrics.CircuitOpen:
+		if now.Sub(b.openedAt) >= b.config.OpenTimeout {
+			b.trials = 0
+			b.setState(metrics.CircuitHalfOpen)
+		}
+	case metrics.CircuitClosed:
+		if now.Sub(b.windowStart) >= b.config.Window {
+			b.windowStart = now
+			b.requests = 0
+			b.failures = 0
+		}
+	}
+}
+
+func (b *Breaker) open() {
+	b.openedAt = b.now()
+	logging.Warn("circuit breaker opened", logrus.Fields{
+		"provider": b.Name(),
+		"requests": b.requests,
+		"failures": b.failures,
+	})
+	b.setState(metrics.CircuitOpen)
+}
+
+func (b *Breaker) close() {
+	b.windowStart = b.now()
+	b.requests = 0
+	b.failures = 0
+	logging.Info("circuit breaker closed", logrus.Fields{
+		"provider": b.Name(),
+	})
+	b.setState(metrics.CircuitClosed)
+}
+
+func (b *Breaker) setState(state string) {
+	b.state = state
+	b.generation++
+	metrics.SetCircuitState(b.Name(), state)
+}
+
+// isProviderFailure reports whether err means the provider itself is failing.
+// Rejected messages and our own cancellations say nothing about its health.
+func isProviderFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var rejected *ErrRejected
+	return !errors.As(err, &rejected)
+}
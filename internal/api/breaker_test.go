@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/starline/rabbitmq-worker/internal/config"
+	"github.com/starline/rabbitmq-worker/internal/metrics"
+)
+
+func newTestBreaker(p Provider) (*Breaker, *time.Time) {
+	now := time.Now()
+	b := NewBreaker(p, config.BreakerConfig{
+		Enabled:          true,
+		FailureRatio:     0.5,
+		MinRequests:      4,
+		Window:           time.Minute,
+		OpenTimeout:      30 * time.Second,
+		HalfOpenRequests: 1,
+	})
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+func TestBreakerOpens(t *testing.T) {
+	p := &scriptedProvider{name: "breaker-opens"}
+	b, _ := newTestBreaker(p)
+	ctx := context.Background()
+
+	// Two successes and two failures reach the 50% ratio
+	b.Send(ctx, Message{})
+	b.Send(ctx, Message{})
+	p.err = &ErrTransient{Err: errors.New("down")}
+	b.Send(ctx, Message{})
+	b.Send(ctx, Message{})
+
+	if state := b.State(); state != metrics.CircuitOpen {
+		t.Fatalf("expected open breaker, got %s", state)
+	}
+
+	calls := p.calls
+	_, err := b.Send(ctx, Message{})
+	if !errors.Is(err, ErrCircuitOpen) || !IsRetryable(err) {
+		t.Errorf("expected retryable circuit open error, got %v", err)
+	}
+	if p.calls != calls {
+		t.Error("expected open breaker not to call the provider")
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	p := &scriptedProvider{name: "breaker-half-open", err: &ErrTransient{Err: errors.New("down")}}
+	b, now := newTestBreaker(p)
+	ctx := context.Background()
+
+	for i := 0; i < 4; i++ {
+		b.Send(ctx, Message{})
+	}
+	if state := b.State(); state != metrics.CircuitOpen {
+		t.Fatalf("expected open breaker, got %s", state)
+	}
+
+	// A failed trial opens the breaker again
+	*now = now.Add(31 * time.Second)
+	if state := b.State(); state != metrics.CircuitHalfOpen {
+		t.Fatalf("expected half-open breaker, got %s", state)
+	}
+	b.Send(ctx, Message{})
+	if state := b.State(); state != metrics.CircuitOpen {
+		t.Fatalf("expected failed trial to reopen breaker, got %s", state)
+	}
+
+	// A successful trial closes it
+	*now = now.Add(31 * time.Second)
+	p.err = nil
+	if _, err := b.Send(ctx, Message{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if state := b.State(); state != metrics.CircuitClosed {
+		t.Errorf("expected closed breaker, got %s", state)
+	}
+}
+
+func TestBreakerHalfOpenInconclusiveTrial(t *testing.T) {
+	p := &scriptedProvider{name: "breaker-inconclusive", err: &ErrTransient{Err: errors.New("down")}}
+	b, now := newTestBreaker(p)
+	ctx := context.Background()
+
+	for i := 0; i < 4; i++ {
+		b.Send(ctx, Message{})
+	}
+	*now = now.Add(31 * time.Second)
+
+	for _, err := range []error{&ErrRejected{StatusCode: 400}, context.Canceled} {
+		p.err = err
+		b.Send(ctx, Message{})
+		if state := b.State(); state != metrics.CircuitHalfOpen {
+			t.Fatalf("expected %v to leave the breaker half-open, got %s", err, state)
+		}
+	}
+
+	// The trial was released, so the next request is let through
+	p.err = nil
+	if _, err := b.Send(ctx, Message{}); err != nil {
+		t.Fatalf("expected trial request, got %v", err)
+	}
+	if state := b.State(); state != metrics.CircuitClosed {
+		t.Errorf("expected closed breaker, got %s", state)
+	}
+}
+
+func TestBreakerIgnoresStaleResults(t *testing.T) {
+	p := &scriptedProvider{name: "breaker-stale"}
+	b, now := newTestBreaker(p)
+
+	// A slow request is let through while the breaker is closed
+	slow, ok := b.allow()
+	if !ok {
+		t.Fatal("expected closed breaker to allow the request")
+	}
+
+	p.err = &ErrTransient{Err: errors.New("down")}
+	for i := 0; i < 4; i++ {
+		b.Send(context.Background(), Message{})
+	}
+	*now = now.Add(31 * time.Second)
+
+	trial, ok := b.allow()
+	if !ok {
+		t.Fatal("expected half-open breaker to allow a trial")
+	}
+
+	// The slow request finishes during the trial and must not decide it
+	b.record(slow, nil)
+	if state := b.State(); state != metrics.CircuitHalfOpen {
+		t.Fatalf("expected stale success to leave the breaker half-open, got %s", state)
+	}
+	if _, ok := b.allow(); ok {
+		t.Error("expected stale result not to release the running trial")
+	}
+
+	b.record(trial, p.err)
+	if state := b.State(); state != metrics.CircuitOpen {
+		t.Errorf("expected failed trial to open the breaker, got %s", state)
+	}
+}
+
+func TestBreakerIgnoresRejected(t *testing.T) {
+	p := &scriptedProvider{name: "breaker-rejected", err: &ErrRejected{StatusCode: 400}}
+	b, _ := newTestBreaker(p)
+
+	for i := 0; i < 10; i++ {
+		b.Send(context.Background(), Message{})
+	}
+	if state := b.State(); state != metrics.CircuitClosed {
+		t.Errorf("expected rejected messages not to open the breaker, got %s", state)
+	}
+}
+
+func TestBreakerWindowReset(t *testing.T) {
+	p := &scriptedProvider{name: "breaker-window", err: &ErrTransient{Err: errors.New("down")}}
+	b, now := newTestBreaker(p)
+
+	for i := 0; i < 3; i++ {
+		b.Send(context.Background(), Message{})
+		*now = now.Add(time.Minute)
+	}
+	if state := b.State(); state != metrics.CircuitClosed {
+		t.Errorf("expected failures spread over windows not to open the breaker, got %s", state)
+	}
+}
@@ -1,6 +1,9 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -15,71 +18,146 @@ import (
 	"github.com/starline/rabbitmq-worker/internal/metrics"
 )
 
-// Client represents HTTP API client
+// Client is the provider for the zagruzka HTTP API
 type Client struct {
 	config     *config.APIConfig
 	httpClient *http.Client
+	redactor   redactor
+	parse      ResponseParser
 }
 
-// NewClient creates new API client
-func NewClient(cfg *config.APIConfig) *Client {
+// Request encodings of the zagruzka API
+const (
+	// EncodingQuery sends all parameters, the password included, in the URL
+	EncodingQuery = "query"
+	// EncodingFormBody sends the parameters as a urlencoded form body
+	EncodingFormBody = "form_body"
+	// EncodingJSONBody sends the parameters as a JSON object body
+	EncodingJSONBody = "json_body"
+)
+
+// Authentication modes that keep the password out of the parameters
+const (
+	AuthBasic  = "basic"
+	AuthBearer = "bearer"
+)
+
+// defaultTimeout limits a single API request when no timeout is configured
+const defaultTimeout = 30 * time.Second
+
+// NewClient creates new API client after checking its encoding, auth and
+// response settings
+func NewClient(cfg *config.APIConfig) (*Client, error) {
+	switch cfg.Encoding {
+	case "", EncodingQuery, EncodingFormBody, EncodingJSONBody:
+	default:
+		return nil, fmt.Errorf("unknown request encoding %q", cfg.Encoding)
+	}
+	switch cfg.Auth {
+	case "", AuthBasic, AuthBearer:
+	default:
+		return nil, fmt.Errorf("unknown auth mode %q", cfg.Auth)
+	}
+
+	parser, err := responseParser(cfg, ResponseZagruzka)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
 	return &Client{
 		config: cfg,
 		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
+			Timeout: timeout,
 		},
+		redactor: redactor{secret: cfg.Pass},
+		parse:    parser,
+	}, nil
+}
+
+// newZagruzka creates a zagruzka client as a registered provider
+func newZagruzka(cfg *config.APIConfig) (Provider, error) {
+	client, err := NewClient(cfg)
+	if err != nil {
+		return nil, err
 	}
+	return client, nil
+}
+
+// Name identifies the provider in logs and metrics
+func (c *Client) Name() string {
+	return providerName(c.config, "zagruzka")
+}
+
+// Send sends msg through the zagruzka HTTP API
+func (c *Client) Send(ctx context.Context, msg Message) (SendResult, error) {
+	result, err := c.send(ctx, msg)
+	result.Provider = c.Name()
+	return result, err
 }
 
 // SendMessage sends message to API endpoint
 func (c *Client) SendMessage(clientID, message string) error {
+	return c.SendMessageContext(context.Background(), clientID, message)
+}
+
+// SendMessageContext sends message to API endpoint, aborting the request when
+// ctx is done. Each request is also limited by the configured timeout.
+func (c *Client) SendMessageContext(ctx context.Context, clientID, message string) error {
+	_, err := c.send(ctx, Message{Recipient: clientID, Body: message})
+	return err
+}
+
+// send sends msg to API endpoint, using the message source when it has one.
+// The password never appears in the returned error.
+func (c *Client) send(ctx context.Context, msg Message) (SendResult, error) {
+	result, err := c.doSend(ctx, msg)
+	return result, c.redactor.redactError(err)
+}
+
+func (c *Client) doSend(ctx context.Context, msg Message) (SendResult, error) {
+	var result SendResult
+	clientID, message := msg.Recipient, msg.Body
+
 	timer := prometheus.NewTimer(metrics.APIRequestDuration)
 	defer timer.ObserveDuration()
 
 	metrics.APIRequestsSent.Inc()
 
-	// Prepare URL parameters
-	params := url.Values{}
-	params.Set("clientId", clientID)
-	params.Set("message", message)
-	params.Set("serviceId", c.config.ServiceID)
-	params.Set("pass", c.config.Pass)
-	params.Set("source", c.config.Source)
-
-	// Create full URL
-	fullURL := fmt.Sprintf("%s?%s", c.config.URL, params.Encode())
-
 	logging.Debug("sending API request", logrus.Fields{
 		"url":       c.config.URL,
 		"client_id": clientID,
 		"message":   message,
+		"encoding":  c.encoding(),
 	})
 
-	// Create POST request
-	req, err := http.NewRequest("POST", fullURL, strings.NewReader(""))
+	req, err := c.newRequest(ctx, msg)
 	if err != nil {
+		err = c.redactor.redactError(err)
 		logging.Error("failed to create API request", err, logrus.Fields{
 			"client_id": clientID,
 		})
 		metrics.APIRequestsFailed.Inc()
-		return fmt.Errorf("failed to create request: %w", err)
+		return result, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	// Set headers
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("User-Agent", "StarLine-RabbitMQ-Worker/1.0")
-
 	// Send request
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
+		err = c.redactor.redactError(err)
 		logging.Error("failed to send API request", err, logrus.Fields{
 			"client_id": clientID,
 			"url":       c.config.URL,
 		})
 		metrics.APIRequestsFailed.Inc()
-		return fmt.Errorf("failed to send request: %w", err)
+		return result, &ErrTransient{Err: fmt.Errorf("failed to send request: %w", err)}
 	}
 	defer resp.Body.Close()
+	result.StatusCode = resp.StatusCode
 
 	// Read response
 	body, err := io.ReadAll(resp.Body)
@@ -89,24 +167,112 @@ func (c *Client) SendMessage(clientID, message string) error {
 			"status_code": resp.StatusCode,
 		})
 		metrics.APIRequestsFailed.Inc()
-		return fmt.Errorf("failed to read response: %w", err)
+		return result, &ErrTransient{
+			StatusCode: resp.StatusCode,
+			Err:        fmt.Errorf("failed to read response: %w", err),
+		}
 	}
 
 	if resp.StatusCode >= 400 {
+		// Gateways sometimes echo the request back in their errors
+		responseBody := c.redactor.redact(string(body))
 		logging.Error("API request failed with error status", nil, logrus.Fields{
-			"client_id":    clientID,
-			"status_code":  resp.StatusCode,
-			"response_body": string(body),
+			"client_id":     clientID,
+			"status_code":   resp.StatusCode,
+			"response_body": responseBody,
 		})
 		metrics.APIRequestsFailed.Inc()
-		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return result, statusError(resp, responseBody)
+	}
+
+	result, err = c.parseResponse(resp.StatusCode, body)
+	if err != nil {
+		logging.Error("API reported an error in a success response", err, logrus.Fields{
+			"client_id":   clientID,
+			"status_code": resp.StatusCode,
+		})
+		metrics.APIRequestsFailed.Inc()
+		return result, err
 	}
 
 	metrics.APIRequestsSuccess.Inc()
 	logging.Info("API request sent successfully", logrus.Fields{
-		"client_id":   clientID,
-		"status_code": resp.StatusCode,
+		"client_id":           clientID,
+		"status_code":         resp.StatusCode,
+		"provider_message_id": result.MessageID,
 	})
 
-	return nil
-}
\ No newline at end of file
+	return result, nil
+}
+
+// parseResponse reads a success response with the configured parser
+func (c *Client) parseResponse(statusCode int, body []byte) (SendResult, error) {
+	return parseWith(c.parse, statusCode, []byte(c.redactor.redact(string(body))))
+}
+
+// newRequest builds the HTTP request for msg in the configured encoding
+func (c *Client) newRequest(ctx context.Context, msg Message) (*http.Request, error) {
+	source := c.config.Source
+	if msg.Source != "" {
+		source = msg.Source
+	}
+
+	params := url.Values{}
+	params.Set("clientId", msg.Recipient)
+	params.Set("message", msg.Body)
+	params.Set("serviceId", c.config.ServiceID)
+	if c.config.Auth == "" {
+		params.Set("pass", c.config.Pass)
+	}
+	params.Set("source", source)
+
+	var (
+		target      = c.config.URL
+		body        io.Reader
+		contentType string
+	)
+	switch c.encoding() {
+	case EncodingFormBody:
+		body = strings.NewReader(params.Encode())
+		contentType = "application/x-www-form-urlencoded"
+	case EncodingJSONBody:
+		fields := map[string]string{}
+		for key := range params {
+			fields[key] = params.Get(key)
+		}
+		payload, err := json.Marshal(fields)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+		body = bytes.NewReader(payload)
+		contentType = "application/json"
+	default:
+		// The legacy encoding, kept as is for gateways that depend on it
+		target = fmt.Sprintf("%s?%s", c.config.URL, params.Encode())
+		body = strings.NewReader("")
+		contentType = "application/x-www-form-urlencoded"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", target, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "StarLine-RabbitMQ-Worker/1.0")
+	switch c.config.Auth {
+	case AuthBasic:
+		req.SetBasicAuth(c.config.ServiceID, c.config.Pass)
+	case AuthBearer:
+		req.Header.Set("Authorization", "Bearer "+c.config.Pass)
+	}
+	return req, nil
+}
+
+// encoding returns the configured request encoding
+func (c *Client) encoding() string {
+	if c.config.Encoding == "" {
+		return EncodingQuery
+	}
+	return c.config.Encoding
+}
@@ -1,13 +1,29 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/starline/rabbitmq-worker/internal/config"
 )
 
+// newTestClient creates a client, failing the test on invalid settings
+func newTestClient(t *testing.T, cfg *config.APIConfig) *Client {
+	t.Helper()
+	client, err := NewClient(cfg)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	return client
+}
+
 func TestNewClient(t *testing.T) {
 	cfg := &config.APIConfig{
 		URL:       "https://example.com/api",
@@ -16,8 +32,10 @@ func TestNewClient(t *testing.T) {
 		Source:    "test_source",
 	}
 
-	client := NewClient(cfg)
-	
+	client, err := NewClient(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
 	if client == nil {
 		t.Fatal("expected client to be created, got nil")
 	}
@@ -27,6 +45,21 @@ func TestNewClient(t *testing.T) {
 	}
 }
 
+func TestNewClientInvalid(t *testing.T) {
+	tests := []config.APIConfig{
+		{Encoding: "xml_body"},
+		{Auth: "digest"},
+		{Response: "xml"},
+	}
+
+	for _, cfg := range tests {
+		cfg := cfg
+		if _, err := NewClient(&cfg); err == nil {
+			t.Errorf("expected error for %+v", cfg)
+		}
+	}
+}
+
 func TestSendMessage(t *testing.T) {
 	// Create test server
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -62,7 +95,7 @@ func TestSendMessage(t *testing.T) {
 		Source:    "test_source",
 	}
 
-	client := NewClient(cfg)
+	client := newTestClient(t, cfg)
 	
 	err := client.SendMessage("79218897127", "Test message")
 	if err != nil {
@@ -85,10 +118,157 @@ func TestSendMessageHTTPError(t *testing.T) {
 		Source:    "test_source",
 	}
 
-	client := NewClient(cfg)
+	client := newTestClient(t, cfg)
 	
 	err := client.SendMessage("79218897127", "Test message")
 	if err == nil {
 		t.Error("expected error for HTTP 500 response")
 	}
-}
\ No newline at end of file
+}
+
+func TestSendMessageContextCancelled(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := newTestClient(t, &config.APIConfig{URL: server.URL})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := client.SendMessageContext(ctx, "79218897127", "Test message")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if !IsRetryable(err) {
+		t.Error("expected cancelled request to be retryable")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected request to be aborted promptly, took %s", elapsed)
+	}
+}
+
+func TestSendMessageTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := newTestClient(t, &config.APIConfig{URL: server.URL, Timeout: 50 * time.Millisecond})
+
+	err := client.SendMessage("79218897127", "Test message")
+	if !IsRetryable(err) {
+		t.Fatalf("expected retryable timeout error, got %v", err)
+	}
+}
+
+func TestSendMessageEncodings(t *testing.T) {
+	tests := []struct {
+		encoding      string
+		auth          string
+		contentType   string
+		authorization string
+	}{
+		{EncodingFormBody, "", "application/x-www-form-urlencoded", ""},
+		{EncodingJSONBody, "", "application/json", ""},
+		{EncodingFormBody, AuthBasic, "application/x-www-form-urlencoded", "Basic dGVzdF9zZXJ2aWNlOnMzY3JldCY="},
+		{EncodingJSONBody, AuthBearer, "application/json", "Bearer s3cret&"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.encoding+"/"+test.auth, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.RawQuery != "" {
+					t.Errorf("expected no query string, got '%s'", r.URL.RawQuery)
+				}
+				if ct := r.Header.Get("Content-Type"); ct != test.contentType {
+					t.Errorf("expected content type '%s', got '%s'", test.contentType, ct)
+				}
+				if auth := r.Header.Get("Authorization"); auth != test.authorization {
+					t.Errorf("expected authorization '%s', got '%s'", test.authorization, auth)
+				}
+
+				params := map[string]string{}
+				if test.encoding == EncodingJSONBody {
+					if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+						t.Fatalf("failed to decode body: %v", err)
+					}
+				} else {
+					r.ParseForm()
+					for key := range r.PostForm {
+						params[key] = r.PostForm.Get(key)
+					}
+				}
+
+				expectedPass := "s3cret&"
+				if test.auth != "" {
+					expectedPass = ""
+				}
+				if params["pass"] != expectedPass {
+					t.Errorf("expected pass '%s' in body, got '%s'", expectedPass, params["pass"])
+				}
+				if params["clientId"] != "79218897127" || params["message"] != "Test message" ||
+					params["serviceId"] != "test_service" || params["source"] != "test_source" {
+					t.Errorf("unexpected parameters %v", params)
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			client := newTestClient(t, &config.APIConfig{
+				URL:       server.URL,
+				ServiceID: "test_service",
+				Pass:      "s3cret&",
+				Source:    "test_source",
+				Encoding:  test.encoding,
+				Auth:      test.auth,
+			})
+			if err := client.SendMessage("79218897127", "Test message"); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestSendMessageRedactsPassword(t *testing.T) {
+	const pass = "s3cret&"
+
+	// The gateway echoes the request, password included, in its error
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("bad request: " + r.URL.RawQuery))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, &config.APIConfig{URL: server.URL, Pass: pass})
+	err := client.SendMessage("79218897127", "Test message")
+	var rejected *ErrRejected
+	if !errors.As(err, &rejected) {
+		t.Fatalf("expected ErrRejected, got %v", err)
+	}
+	for _, s := range []string{err.Error(), rejected.Body} {
+		if strings.Contains(s, "s3cret") {
+			t.Errorf("expected password to be redacted, got %q", s)
+		}
+	}
+
+	// Connection errors carry the full request URL
+	server.Close()
+	err = client.SendMessage("79218897127", "Test message")
+	if !IsRetryable(err) {
+		t.Fatalf("expected retryable error, got %v", err)
+	}
+	if strings.Contains(err.Error(), "s3cret") {
+		t.Errorf("expected password to be redacted, got %q", err.Error())
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) && strings.Contains(urlErr.Error(), "s3cret") {
+		t.Errorf("expected wrapped URL error to be redacted, got %q", urlErr.Error())
+	}
+}
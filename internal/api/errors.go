@@ -0,0 +1,109 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrTransient is a temporary failure such as a network error, a timeout or a
+// 5xx response. The request may succeed if retried later.
+type ErrTransient struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *ErrTransient) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("transient API error (status %d): %v", e.StatusCode, e.Err)
+	}
+	return fmt.Sprintf("transient API error: %v", e.Err)
+}
+
+func (e *ErrTransient) Unwrap() error {
+	return e.Err
+}
+
+// ErrRateLimited is returned when the provider answers 429 Too Many Requests
+type ErrRateLimited struct {
+	// RetryAfter is the wait requested by the provider, zero if not given
+	RetryAfter time.Duration
+	Body       string
+}
+
+func (e *ErrRateLimited) Error() string {
+	return fmt.Sprintf("API rate limit exceeded (retry after %s): %s", e.RetryAfter, e.Body)
+}
+
+// ErrRejected is a permanent rejection of the message itself, for example an
+// invalid recipient number. Retrying it will not help.
+type ErrRejected struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *ErrRejected) Error() string {
+	return fmt.Sprintf("API rejected message with status %d: %s", e.StatusCode, e.Body)
+}
+
+// ErrAuth is returned when the provider does not accept our credentials
+type ErrAuth struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *ErrAuth) Error() string {
+	return fmt.Sprintf("API authentication failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+// IsRetryable reports whether err is a failure that may succeed on retry
+func IsRetryable(err error) bool {
+	var transient *ErrTransient
+	var rateLimited *ErrRateLimited
+	return errors.As(err, &transient) || errors.As(err, &rateLimited)
+}
+
+// IsPermanent reports whether err is a failure that will not succeed on retry
+func IsPermanent(err error) bool {
+	var rejected *ErrRejected
+	var auth *ErrAuth
+	return errors.As(err, &rejected) || errors.As(err, &auth)
+}
+
+// statusError converts an HTTP error status into a typed error
+func statusError(resp *http.Response, body string) error {
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return &ErrRateLimited{
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+			Body:       body,
+		}
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return &ErrAuth{StatusCode: resp.StatusCode, Body: body}
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode >= 500:
+		return &ErrTransient{
+			StatusCode: resp.StatusCode,
+			Err:        errors.New(body),
+		}
+	default:
+		return &ErrRejected{StatusCode: resp.StatusCode, Body: body}
+	}
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/starline/rabbitmq-worker/internal/config"
+)
+
+func TestSendMessageErrorClassification(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		retryable  bool
+		check      func(error) bool
+	}{
+		{"server error", http.StatusBadGateway, "", true, func(err error) bool {
+			var e *ErrTransient
+			return errors.As(err, &e) && e.StatusCode == http.StatusBadGateway
+		}},
+		{"rate limited", http.StatusTooManyRequests, "30", true, func(err error) bool {
+			var e *ErrRateLimited
+			return errors.As(err, &e) && e.RetryAfter == 30*time.Second
+		}},
+		{"bad credentials", http.StatusUnauthorized, "", false, func(err error) bool {
+			var e *ErrAuth
+			return errors.As(err, &e)
+		}},
+		{"invalid number", http.StatusBadRequest, "", false, func(err error) bool {
+			var e *ErrRejected
+			return errors.As(err, &e) && e.Body == "invalid clientId"
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if test.retryAfter != "" {
+					w.Header().Set("Retry-After", test.retryAfter)
+				}
+				w.WriteHeader(test.status)
+				w.Write([]byte("invalid clientId"))
+			}))
+			defer server.Close()
+
+			client := newTestClient(t, &config.APIConfig{URL: server.URL})
+
+			err := client.SendMessage("79218897127", "Test message")
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !test.check(err) {
+				t.Errorf("unexpected error type %T: %v", err, err)
+			}
+			if IsRetryable(err) != test.retryable {
+				t.Errorf("expected retryable=%v for %v", test.retryable, err)
+			}
+			if IsPermanent(err) == test.retryable {
+				t.Errorf("expected permanent=%v for %v", !test.retryable, err)
+			}
+		})
+	}
+}
+
+func TestSendMessageNetworkError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+
+	client := newTestClient(t, &config.APIConfig{URL: server.URL})
+
+	err := client.SendMessage("79218897127", "Test message")
+	var transient *ErrTransient
+	if !errors.As(err, &transient) {
+		t.Fatalf("expected ErrTransient, got %T: %v", err, err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("120"); got != 2*time.Minute {
+		t.Errorf("expected 2m, got %s", got)
+	}
+	if got := parseRetryAfter(""); got != 0 {
+		t.Errorf("expected 0, got %s", got)
+	}
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(date); got <= 58*time.Minute {
+		t.Errorf("expected about 1h, got %s", got)
+	}
+}
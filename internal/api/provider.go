@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/starline/rabbitmq-worker/internal/config"
+)
+
+// Message is an SMS handed to a provider
+type Message struct {
+	// ID identifies the message to the gateway, may be empty
+	ID        string
+	Recipient string
+	Body      string
+	// Source overrides the configured sender name when not empty
+	Source string
+	// Type is the message type (otp, notification, marketing), may be empty
+	Type     string
+	Priority int
+	// CallbackURL receives delivery reports for gateways that support it
+	CallbackURL string
+}
+
+// SendResult describes a message accepted by a provider. Apart from
+// Provider, fields are only set when the gateway reports them.
+type SendResult struct {
+	// Provider is the name of the provider that accepted the message
+	Provider string
+	// StatusCode of the gateway's HTTP response
+	StatusCode int
+	// MessageID is the gateway's ID of the message, used to match delivery
+	// reports and in support requests
+	MessageID string
+	// Status is the message status reported by the gateway
+	Status string
+	// Segments is the number of SMS parts the gateway billed
+	Segments int
+	// Price of the message in the gateway's currency
+	Price float64
+}
+
+// Provider sends SMS messages through a gateway. Errors should be one of the
+// typed errors of this package so the caller can decide whether to retry.
+type Provider interface {
+	// Name identifies the provider in logs and metrics
+	Name() string
+	// Send delivers msg to the gateway
+	Send(ctx context.Context, msg Message) (SendResult, error)
+}
+
+// ProviderFactory creates a provider from its configuration
+type ProviderFactory func(cfg *config.APIConfig) (Provider, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]ProviderFactory{
+		"zagruzka": newZagruzka,
+		"webhook":  func(cfg *config.APIConfig) (Provider, error) { return NewWebhook(cfg) },
+	}
+)
+
+// Register makes a provider type available to NewProvider
+func Register(kind string, factory ProviderFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[kind] = factory
+}
+
+// NewProvider creates the provider selected by cfg.Type, zagruzka by default
+func NewProvider(cfg *config.APIConfig) (Provider, error) {
+	kind := cfg.Type
+	if kind == "" {
+		kind = "zagruzka"
+	}
+
+	registryMu.RLock()
+	factory, ok := registry[kind]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown provider type %q", kind)
+	}
+	return factory(cfg)
+}
+
+// providerName returns the configured name of a provider or its type
+func providerName(cfg *config.APIConfig, kind string) string {
+	if cfg.Name != "" {
+		return cfg.Name
+	}
+	return kind
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/starline/rabbitmq-worker/internal/config"
+)
+
+type stubProvider struct{}
+
+func (stubProvider) Name() string { return "stub" }
+
+func (stubProvider) Send(ctx context.Context, msg Message) (SendResult, error) {
+	return SendResult{Provider: "stub"}, nil
+}
+
+func TestNewProvider(t *testing.T) {
+	p, err := NewProvider(&config.APIConfig{URL: "https://example.com/api"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := p.(*Client); !ok {
+		t.Errorf("expected zagruzka client by default, got %T", p)
+	}
+	if p.Name() != "zagruzka" {
+		t.Errorf("expected name 'zagruzka', got '%s'", p.Name())
+	}
+
+	p, err = NewProvider(&config.APIConfig{Type: "webhook", Name: "backup", URL: "https://example.com/hook"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.Name() != "backup" {
+		t.Errorf("expected name 'backup', got '%s'", p.Name())
+	}
+
+	if _, err := NewProvider(&config.APIConfig{Type: "carrier-pigeon"}); err == nil {
+		t.Error("expected error for unknown provider type")
+	}
+	if _, err := NewProvider(&config.APIConfig{Encoding: "xml_body"}); err == nil {
+		t.Error("expected error for unknown request encoding")
+	}
+	if _, err := NewProvider(&config.APIConfig{Auth: "digest"}); err == nil {
+		t.Error("expected error for unknown auth mode")
+	}
+}
+
+func TestRegister(t *testing.T) {
+	Register("stub", func(cfg *config.APIConfig) (Provider, error) {
+		return stubProvider{}, nil
+	})
+
+	p, err := NewProvider(&config.APIConfig{Type: "stub"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.Name() != "stub" {
+		t.Errorf("expected registered provider, got %T", p)
+	}
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// redacted replaces secrets in error messages
+const redacted = "REDACTED"
+
+// redactor removes a secret from strings and errors, in plain and URL-encoded
+// form
+type redactor struct {
+	secret string
+}
+
+func (r redactor) redact(s string) string {
+	if r.secret == "" {
+		return s
+	}
+	for _, form := range []string{r.secret, url.QueryEscape(r.secret), url.PathEscape(r.secret)} {
+		s = strings.ReplaceAll(s, form, redacted)
+	}
+	return s
+}
+
+// redactError returns err with the secret removed from its message. The
+// original error is still reachable with errors.Is and errors.As so the
+// failure can be classified. The URL of a *url.Error is redacted in place.
+func (r redactor) redactError(err error) error {
+	if err == nil || r.secret == "" {
+		return err
+	}
+
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = r.redact(urlErr.URL)
+	}
+
+	msg := err.Error()
+	if clean := r.redact(msg); clean != msg {
+		return &redactedError{msg: clean, err: err}
+	}
+	return err
+}
+
+// redactedError is an error whose message had a secret removed
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/starline/rabbitmq-worker/internal/config"
+)
+
+// Response formats understood by the built-in parsers
+const (
+	// ResponseZagruzka is a JSON object or a plain text message ID, or an
+	// "ERROR ..." line for a failure
+	ResponseZagruzka = "zagruzka"
+	// ResponseJSON is a JSON object, see parseJSONResponse for the fields
+	ResponseJSON = "json"
+	// ResponseNone ignores the body and trusts the status code
+	ResponseNone = "none"
+)
+
+// ResponseParser extracts the outcome of a send from the body of a response
+// with a success status code. It returns an error when the gateway reports a
+// failure in the body despite the status code.
+type ResponseParser func(body []byte) (SendResult, error)
+
+var responseParsers = map[string]ResponseParser{
+	ResponseZagruzka: parseZagruzkaResponse,
+	ResponseJSON:     parseJSONResponse,
+	ResponseNone:     func([]byte) (SendResult, error) { return SendResult{}, nil },
+}
+
+// responseParser returns the parser selected by cfg.Response, or the one for
+// the provider's native format when none is configured
+func responseParser(cfg *config.APIConfig, native string) (ResponseParser, error) {
+	format := cfg.Response
+	if format == "" {
+		format = native
+	}
+	parser, ok := responseParsers[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown response format %q", format)
+	}
+	return parser, nil
+}
+
+// parseWith runs parse on a success response and fills in the status code
+func parseWith(parse ResponseParser, statusCode int, body []byte) (SendResult, error) {
+	result, err := parse(body)
+	result.StatusCode = statusCode
+
+	var (
+		rejected  *ErrRejected
+		auth      *ErrAuth
+		transient *ErrTransient
+	)
+	switch {
+	case errors.As(err, &rejected):
+		rejected.StatusCode = statusCode
+	case errors.As(err, &auth):
+		auth.StatusCode = statusCode
+	case errors.As(err, &transient):
+		transient.StatusCode = statusCode
+	}
+	return result, err
+}
+
+// Words in a gateway's error text that tell what it refused. Credential
+// errors and message-level rejections are checked in that order, any other
+// error is taken as a problem on the gateway's side.
+var (
+	authErrorWords    = []string{"password", "login", "auth", "credential", "token", "api key", "apikey", "access denied", "forbidden"}
+	messageErrorWords = []string{"number", "phone", "recipient", "destination", "msisdn", "sender", "text", "length", "too long", "blacklist", "stop list", "stoplist", "spam"}
+)
+
+// bodyError classifies an error reported in the body of a success response.
+// Only message-level rejections are permanent for the message. Credential
+// errors are ErrAuth so other providers are tried, and unknown errors, like
+// an outage reported with a 200, are ErrTransient so they are retried and
+// counted by the circuit breaker.
+func bodyError(body, text string) error {
+	lower := strings.ToLower(text)
+	switch {
+	case containsAny(lower, authErrorWords):
+		return &ErrAuth{Body: body}
+	case containsAny(lower, messageErrorWords):
+		return &ErrRejected{Body: body}
+	default:
+		return &ErrTransient{Err: fmt.Errorf("gateway reported an error: %s", body)}
+	}
+}
+
+// containsAny reports whether s contains one of words
+func containsAny(s string, words []string) bool {
+	for _, word := range words {
+		if strings.Contains(s, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// Field names gateways commonly use, tried in order
+var (
+	idFields       = []string{"message_id", "messageId", "sms_id", "id"}
+	statusFields   = []string{"status", "state"}
+	segmentsFields = []string{"segments", "parts", "sms_count"}
+	priceFields    = []string{"price", "cost"}
+	errorFields    = []string{"error", "error_message", "error_code", "errorCode"}
+)
+
+// failedStatuses are status values that mean the message was not accepted
+var failedStatuses = map[string]bool{
+	"error":    true,
+	"failed":   true,
+	"rejected": true,
+}
+
+// parseJSONResponse reads a JSON object with an ID, status, segment count,
+// price and error. A non-empty error or a failed status is a failure,
+// classified by bodyError. A rejected status without an error is a rejection
+// of the message.
+func parseJSONResponse(body []byte) (SendResult, error) {
+	var result SendResult
+	if len(bytes.TrimSpace(body)) == 0 {
+		return result, nil
+	}
+
+	var fields map[string]any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		// An unreadable answer doesn't say the message was refused
+		return result, &ErrTransient{Err: fmt.Errorf("failed to decode response %q: %w", body, err)}
+	}
+
+	result.MessageID = stringField(fields, idFields)
+	result.Status = stringField(fields, statusFields)
+	if n, err := strconv.Atoi(stringField(fields, segmentsFields)); err == nil {
+		result.Segments = n
+	}
+	if price, err := strconv.ParseFloat(stringField(fields, priceFields), 64); err == nil {
+		result.Price = price
+	}
+
+	status := strings.ToLower(result.Status)
+	e := stringField(fields, errorFields)
+	switch {
+	case e != "" && e != "0":
+		return result, bodyError(string(body), e)
+	case status == "rejected":
+		return result, &ErrRejected{Body: string(body)}
+	case failedStatuses[status]:
+		return result, bodyError(string(body), "")
+	}
+	return result, nil
+}
+
+// parseZagruzkaResponse reads a JSON response like parseJSONResponse, or a
+// plain text one holding the message ID, optionally after "OK"
+func parseZagruzkaResponse(body []byte) (SendResult, error) {
+	text := strings.TrimSpace(string(body))
+	if strings.HasPrefix(text, "{") {
+		return parseJSONResponse(body)
+	}
+
+	var result SendResult
+	if len(text) >= 5 && strings.EqualFold(text[:5], "error") {
+		return result, bodyError(text, text[5:])
+	}
+
+	if len(text) >= 2 && strings.EqualFold(text[:2], "ok") {
+		text = strings.TrimLeft(text[2:], " :;,")
+	}
+	if text != "" && !strings.ContainsAny(text, " \t\n") {
+		result.MessageID = text
+	}
+	return result, nil
+}
+
+// stringField returns the first of names present in fields as a string
+func stringField(fields map[string]any, names []string) string {
+	for _, name := range names {
+		switch v := fields[name].(type) {
+		case string:
+			return v
+		case json.Number:
+			return v.String()
+		case bool:
+			if v {
+				return "true"
+			}
+			return ""
+		}
+	}
+	return ""
+}
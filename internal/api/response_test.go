@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/starline/rabbitmq-worker/internal/config"
+)
+
+// errorKind names the type of a send error for table tests
+func errorKind(err error) string {
+	var (
+		rejected  *ErrRejected
+		auth      *ErrAuth
+		transient *ErrTransient
+	)
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &rejected):
+		return "rejected"
+	case errors.As(err, &auth):
+		return "auth"
+	case errors.As(err, &transient):
+		return "transient"
+	}
+	return "other"
+}
+
+func TestParseResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		parser   ResponseParser
+		body     string
+		expected SendResult
+		err      string
+	}{
+		{"json", parseJSONResponse, `{"message_id":"a1b2","status":"queued","segments":2,"price":"1.25"}`,
+			SendResult{MessageID: "a1b2", Status: "queued", Segments: 2, Price: 1.25}, ""},
+		{"json numeric id", parseJSONResponse, `{"id":123456789012,"parts":1,"cost":0.9}`,
+			SendResult{MessageID: "123456789012", Segments: 1, Price: 0.9}, ""},
+		{"json empty", parseJSONResponse, ``, SendResult{}, ""},
+		{"json invalid sender", parseJSONResponse, `{"error":"invalid sender"}`, SendResult{}, "rejected"},
+		{"json invalid number", parseJSONResponse, `{"error":"Invalid phone number"}`, SendResult{}, "rejected"},
+		{"json bad credentials", parseJSONResponse, `{"error":"Invalid API key"}`, SendResult{}, "auth"},
+		{"json unknown error", parseJSONResponse, `{"error":"internal error, try later"}`, SendResult{}, "transient"},
+		{"json error code", parseJSONResponse, `{"error_code":17}`, SendResult{}, "transient"},
+		{"json zero error code", parseJSONResponse, `{"id":"x","error_code":0}`, SendResult{MessageID: "x"}, ""},
+		{"json rejected status", parseJSONResponse, `{"id":"x","status":"Rejected"}`, SendResult{MessageID: "x", Status: "Rejected"}, "rejected"},
+		{"json failed status", parseJSONResponse, `{"id":"x","status":"failed"}`, SendResult{MessageID: "x", Status: "failed"}, "transient"},
+		{"json garbage", parseJSONResponse, `<html>`, SendResult{}, "transient"},
+		{"zagruzka id", parseZagruzkaResponse, "4815162342\n", SendResult{MessageID: "4815162342"}, ""},
+		{"zagruzka ok id", parseZagruzkaResponse, "OK: 4815162342", SendResult{MessageID: "4815162342"}, ""},
+		{"zagruzka ok", parseZagruzkaResponse, "OK", SendResult{}, ""},
+		{"zagruzka text", parseZagruzkaResponse, "Message accepted", SendResult{}, ""},
+		{"zagruzka wrong password", parseZagruzkaResponse, "ERROR 3: wrong password", SendResult{}, "auth"},
+		{"zagruzka invalid number", parseZagruzkaResponse, "ERROR 7: invalid clientId number", SendResult{}, "rejected"},
+		{"zagruzka outage", parseZagruzkaResponse, "ERROR: service temporarily unavailable", SendResult{}, "transient"},
+		{"zagruzka json", parseZagruzkaResponse, `{"id":"z1"}`, SendResult{MessageID: "z1"}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := test.parser([]byte(test.body))
+			if kind := errorKind(err); kind != test.err {
+				t.Errorf("expected error kind %q, got %q: %v", test.err, kind, err)
+			}
+			if result != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestClientSendResult(t *testing.T) {
+	body := `{"message_id":"a1b2","segments":2,"price":1.5}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, &config.APIConfig{URL: server.URL})
+
+	result, err := client.Send(context.Background(), Message{Recipient: "79218897127", Body: "test"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := SendResult{Provider: "zagruzka", StatusCode: 200, MessageID: "a1b2", Segments: 2, Price: 1.5}
+	if result != expected {
+		t.Errorf("expected %+v, got %+v", expected, result)
+	}
+
+	// A 200 carrying an error is a failure, retried unless the message is refused
+	body = `ERROR: insufficient funds`
+	_, err = client.Send(context.Background(), Message{Recipient: "79218897127", Body: "test"})
+	var transient *ErrTransient
+	if !errors.As(err, &transient) || transient.StatusCode != http.StatusOK || !IsRetryable(err) {
+		t.Errorf("expected retryable ErrTransient with status 200, got %v", err)
+	}
+
+	body = `ERROR: invalid phone number`
+	_, err = client.Send(context.Background(), Message{Recipient: "79218897127", Body: "test"})
+	var rejected *ErrRejected
+	if !errors.As(err, &rejected) || rejected.StatusCode != http.StatusOK {
+		t.Errorf("expected ErrRejected with status 200, got %v", err)
+	}
+
+	body = `ERROR: wrong password`
+	_, err = client.Send(context.Background(), Message{Recipient: "79218897127", Body: "test"})
+	var auth *ErrAuth
+	if !errors.As(err, &auth) || auth.StatusCode != http.StatusOK {
+		t.Errorf("expected ErrAuth with status 200, got %v", err)
+	}
+}
+
+func TestResponseFormatConfig(t *testing.T) {
+	if _, err := NewProvider(&config.APIConfig{Response: "xml"}); err == nil {
+		t.Error("expected error for unknown response format")
+	}
+	if _, err := NewWebhook(&config.APIConfig{URL: "https://example.com", Response: "xml"}); err == nil {
+		t.Error("expected error for unknown response format")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"error":"ignored"}`))
+	}))
+	defer server.Close()
+
+	hook, _ := NewWebhook(&config.APIConfig{URL: server.URL, Response: ResponseNone})
+	if _, err := hook.Send(context.Background(), Message{Recipient: "79218897127", Body: "test"}); err != nil {
+		t.Errorf("expected body to be ignored, got %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+	"github.com/starline/rabbitmq-worker/internal/config"
+	"github.com/starline/rabbitmq-worker/internal/logging"
+	"github.com/starline/rabbitmq-worker/internal/metrics"
+)
+
+// Router is a provider that spreads messages over several providers. Traffic
+// is split by weight between the providers of the highest priority, and a
+// failed send fails over to the next provider.
+type Router struct {
+	// groups holds providers of equal priority, highest priority first
+	groups [][]weightedProvider
+	// random returns a number in [0, 1), replaced in tests
+	random func() float64
+}
+
+type weightedProvider struct {
+	provider Provider
+	weight   int
+}
+
+// NewRouter creates providers from their configurations and routes between them
+func NewRouter(cfgs []config.APIConfig) (*Router, error) {
+	if len(cfgs) == 0 {
+		return nil, fmt.Errorf("no providers configured")
+	}
+
+	byPriority := map[int][]weightedProvider{}
+	for i := range cfgs {
+		p, err := NewProvider(&cfgs[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to create provider %d: %w", i, err)
+		}
+		if cfgs[i].Breaker.Enabled {
+			p = NewBreaker(p, cfgs[i].Breaker)
+		}
+		byPriority[cfgs[i].Priority] = append(byPriority[cfgs[i].Priority], weightedProvider{
+			provider: p,
+			weight:   max(cfgs[i].Weight, 1),
+		})
+	}
+
+	return newRouter(byPriority), nil
+}
+
+func newRouter(byPriority map[int][]weightedProvider) *Router {
+	priorities := make([]int, 0, len(byPriority))
+	for priority := range byPriority {
+		priorities = append(priorities, priority)
+	}
+	sort.Ints(priorities)
+
+	r := &Router{random: rand.Float64}
+	for _, priority := range priorities {
+		r.groups = append(r.groups, byPriority[priority])
+	}
+	return r
+}
+
+// Name identifies the provider in logs and metrics
+func (r *Router) Name() string {
+	return "router"
+}
+
+// Send tries providers in priority order, picking by weight within a
+// priority, until one accepts the message. A rejected message is not retried
+// with other providers since they would reject it too.
+func (r *Router) Send(ctx context.Context, msg Message) (SendResult, error) {
+	var (
+		result SendResult
+		err    error
+	)
+
+	for _, group := range r.groups {
+		for _, p := range r.order(group) {
+			if ctx.Err() != nil {
+				return result, &ErrTransient{Err: ctx.Err()}
+			}
+			if err != nil {
+				logging.Warn("failing over to next provider", logrus.Fields{
+					"from":  result.Provider,
+					"to":    p.Name(),
+					"error": err.Error(),
+				})
+				metrics.ProviderFailovers.WithLabelValues(result.Provider).Inc()
+			}
+
+			result, err = p.Send(ctx, msg)
+			if result.Provider == "" {
+				result.Provider = p.Name()
+			}
+			if err == nil {
+				metrics.ProviderRequests.WithLabelValues(result.Provider, "success").Inc()
+				return result, nil
+			}
+			metrics.ProviderRequests.WithLabelValues(result.Provider, "failure").Inc()
+
+			var rejected *ErrRejected
+			if errors.As(err, &rejected) {
+				return result, err
+			}
+		}
+	}
+
+	return result, err
+}
+
+// order returns the providers of a group in weighted random order
+func (r *Router) order(group []weightedProvider) []Provider {
+	remaining := append([]weightedProvider(nil), group...)
+	ordered := make([]Provider, 0, len(group))
+
+	for len(remaining) > 0 {
+		total := 0
+		for _, p := range remaining {
+			total += p.weight
+		}
+
+		pick := int(r.random() * float64(total))
+		i := 0
+		for ; i < len(remaining)-1; i++ {
+			if pick < remaining[i].weight {
+				break
+			}
+			pick -= remaining[i].weight
+		}
+
+		ordered = append(ordered, remaining[i].provider)
+		remaining = append(remaining[:i], remaining[i+1:]...)
+	}
+	return ordered
+}
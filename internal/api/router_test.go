@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/starline/rabbitmq-worker/internal/config"
+)
+
+// scriptedProvider returns a fixed error and counts its calls
+type scriptedProvider struct {
+	name  string
+	err   error
+	calls int
+}
+
+func (p *scriptedProvider) Name() string { return p.name }
+
+func (p *scriptedProvider) Send(ctx context.Context, msg Message) (SendResult, error) {
+	p.calls++
+	return SendResult{Provider: p.name}, p.err
+}
+
+func TestRouterFailover(t *testing.T) {
+	primary := &scriptedProvider{name: "primary", err: &ErrTransient{Err: errors.New("timeout")}}
+	backup := &scriptedProvider{name: "backup"}
+
+	r := newRouter(map[int][]weightedProvider{
+		0: {{provider: primary, weight: 1}},
+		1: {{provider: backup, weight: 1}},
+	})
+
+	result, err := r.Send(context.Background(), Message{Recipient: "79218897127"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Provider != "backup" {
+		t.Errorf("expected backup provider, got '%s'", result.Provider)
+	}
+	if primary.calls != 1 || backup.calls != 1 {
+		t.Errorf("expected one call each, got primary=%d backup=%d", primary.calls, backup.calls)
+	}
+}
+
+func TestRouterRejectedDoesNotFailOver(t *testing.T) {
+	primary := &scriptedProvider{name: "primary", err: &ErrRejected{StatusCode: 400}}
+	backup := &scriptedProvider{name: "backup"}
+
+	r := newRouter(map[int][]weightedProvider{
+		0: {{provider: primary, weight: 1}},
+		1: {{provider: backup, weight: 1}},
+	})
+
+	_, err := r.Send(context.Background(), Message{Recipient: "invalid"})
+	if !IsPermanent(err) {
+		t.Errorf("expected permanent error, got %v", err)
+	}
+	if backup.calls != 0 {
+		t.Error("expected rejected message not to be sent to backup")
+	}
+}
+
+func TestRouterAllFail(t *testing.T) {
+	a := &scriptedProvider{name: "a", err: &ErrTransient{Err: errors.New("down")}}
+	b := &scriptedProvider{name: "b", err: &ErrRateLimited{}}
+
+	r := newRouter(map[int][]weightedProvider{
+		0: {{provider: a, weight: 1}, {provider: b, weight: 1}},
+	})
+
+	_, err := r.Send(context.Background(), Message{})
+	if !IsRetryable(err) {
+		t.Errorf("expected retryable error, got %v", err)
+	}
+	if a.calls != 1 || b.calls != 1 {
+		t.Errorf("expected both providers to be tried, got a=%d b=%d", a.calls, b.calls)
+	}
+}
+
+func TestRouterWeights(t *testing.T) {
+	heavy := &scriptedProvider{name: "heavy"}
+	light := &scriptedProvider{name: "light"}
+
+	r := newRouter(map[int][]weightedProvider{
+		0: {{provider: heavy, weight: 3}, {provider: light, weight: 1}},
+	})
+
+	// Picks below 3/4 of the total weight go to the heavy provider
+	for _, test := range []struct {
+		random   float64
+		expected string
+	}{
+		{0.0, "heavy"},
+		{0.7, "heavy"},
+		{0.8, "light"},
+		{0.99, "light"},
+	} {
+		r.random = func() float64 { return test.random }
+		result, _ := r.Send(context.Background(), Message{})
+		if result.Provider != test.expected {
+			t.Errorf("random %.2f: expected '%s', got '%s'", test.random, test.expected, result.Provider)
+		}
+	}
+}
+
+func TestNewRouter(t *testing.T) {
+	if _, err := NewRouter(nil); err == nil {
+		t.Error("expected error without providers")
+	}
+
+	r, err := NewRouter([]config.APIConfig{
+		{Name: "backup", Type: "webhook", URL: "https://example.com/hook", Priority: 1},
+		{Name: "main", URL: "https://example.com/api"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(r.groups) != 2 || r.groups[0][0].provider.Name() != "main" {
+		t.Errorf("expected main provider to have the highest priority")
+	}
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/starline/rabbitmq-worker/internal/config"
+	"github.com/starline/rabbitmq-worker/internal/logging"
+	"github.com/starline/rabbitmq-worker/internal/metrics"
+)
+
+// Webhook is a provider that POSTs messages as JSON to a generic HTTP gateway
+type Webhook struct {
+	config     *config.APIConfig
+	httpClient *http.Client
+	parse      ResponseParser
+}
+
+// webhookRequest is the JSON body sent to the gateway
+type webhookRequest struct {
+	ID          string `json:"id,omitempty"`
+	Recipient   string `json:"recipient"`
+	Body        string `json:"body"`
+	Source      string `json:"source,omitempty"`
+	Type        string `json:"type,omitempty"`
+	Priority    int    `json:"priority,omitempty"`
+	CallbackURL string `json:"callback_url,omitempty"`
+}
+
+// NewWebhook creates a JSON webhook provider
+func NewWebhook(cfg *config.APIConfig) (*Webhook, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("webhook provider requires url")
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	parser, err := responseParser(cfg, ResponseJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Webhook{
+		config: cfg,
+		parse:  parser,
+		httpClient: &http.Client{
+			Timeout: timeout,
+		},
+	}, nil
+}
+
+// Name identifies the provider in logs and metrics
+func (h *Webhook) Name() string {
+	return providerName(h.config, "webhook")
+}
+
+// Send posts msg to the gateway
+func (h *Webhook) Send(ctx context.Context, msg Message) (SendResult, error) {
+	timer := prometheus.NewTimer(metrics.APIRequestDuration)
+	defer timer.ObserveDuration()
+
+	metrics.APIRequestsSent.Inc()
+	result := SendResult{Provider: h.Name()}
+
+	source := h.config.Source
+	if msg.Source != "" {
+		source = msg.Source
+	}
+
+	payload, err := json.Marshal(webhookRequest{
+		ID:          msg.ID,
+		Recipient:   msg.Recipient,
+		Body:        msg.Body,
+		Source:      source,
+		Type:        msg.Type,
+		Priority:    msg.Priority,
+		CallbackURL: msg.CallbackURL,
+	})
+	if err != nil {
+		metrics.APIRequestsFailed.Inc()
+		return result, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", h.config.URL, bytes.NewReader(payload))
+	if err != nil {
+		metrics.APIRequestsFailed.Inc()
+		return result, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "StarLine-RabbitMQ-Worker/1.0")
+	for k, v := range h.config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		logging.Error("failed to send webhook request", err, logrus.Fields{
+			"provider":  h.Name(),
+			"client_id": msg.Recipient,
+		})
+		metrics.APIRequestsFailed.Inc()
+		return result, &ErrTransient{Err: fmt.Errorf("failed to send request: %w", err)}
+	}
+	defer resp.Body.Close()
+	result.StatusCode = resp.StatusCode
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		metrics.APIRequestsFailed.Inc()
+		return result, &ErrTransient{
+			StatusCode: resp.StatusCode,
+			Err:        fmt.Errorf("failed to read response: %w", err),
+		}
+	}
+
+	if resp.StatusCode >= 400 {
+		logging.Error("webhook request failed with error status", nil, logrus.Fields{
+			"provider":      h.Name(),
+			"client_id":     msg.Recipient,
+			"status_code":   resp.StatusCode,
+			"response_body": string(body),
+		})
+		metrics.APIRequestsFailed.Inc()
+		return result, statusError(resp, string(body))
+	}
+
+	parsed, err := parseWith(h.parse, resp.StatusCode, body)
+	parsed.Provider = result.Provider
+	if err != nil {
+		logging.Error("webhook reported an error in a success response", err, logrus.Fields{
+			"provider":    h.Name(),
+			"client_id":   msg.Recipient,
+			"status_code": resp.StatusCode,
+		})
+		metrics.APIRequestsFailed.Inc()
+		return parsed, err
+	}
+
+	metrics.APIRequestsSuccess.Inc()
+	return parsed, nil
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/starline/rabbitmq-worker/internal/config"
+)
+
+func TestWebhookSend(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("expected JSON content type, got '%s'", ct)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer token" {
+			t.Errorf("expected configured header, got '%s'", auth)
+		}
+
+		var req webhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		if req.Recipient != "79218897127" || req.Body != "Test message" || req.Source != "StarLine" {
+			t.Errorf("unexpected request %+v", req)
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	hook, err := NewWebhook(&config.APIConfig{
+		URL:     server.URL,
+		Source:  "StarLine",
+		Headers: map[string]string{"Authorization": "Bearer token"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	result, err := hook.Send(context.Background(), Message{Recipient: "79218897127", Body: "Test message"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Provider != "webhook" {
+		t.Errorf("expected provider 'webhook', got '%s'", result.Provider)
+	}
+}
+
+func TestWebhookSendMessageFields(t *testing.T) {
+	var req webhookRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&req)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	hook, _ := NewWebhook(&config.APIConfig{URL: server.URL, Source: "StarLine"})
+
+	_, err := hook.Send(context.Background(), Message{
+		ID:          "42",
+		Recipient:   "79218897127",
+		Body:        "Test message",
+		Source:      "StarLine-M",
+		Type:        "otp",
+		Priority:    9,
+		CallbackURL: "https://example.com/dlr",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := webhookRequest{
+		ID:          "42",
+		Recipient:   "79218897127",
+		Body:        "Test message",
+		Source:      "StarLine-M",
+		Type:        "otp",
+		Priority:    9,
+		CallbackURL: "https://example.com/dlr",
+	}
+	if req != expected {
+		t.Errorf("expected request %+v, got %+v", expected, req)
+	}
+}
+
+func TestWebhookSendError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	hook, _ := NewWebhook(&config.APIConfig{URL: server.URL})
+
+	_, err := hook.Send(context.Background(), Message{Recipient: "invalid", Body: "Test message"})
+	if !IsPermanent(err) {
+		t.Errorf("expected permanent error, got %v", err)
+	}
+}
+
+func TestNewWebhookRequiresURL(t *testing.T) {
+	if _, err := NewWebhook(&config.APIConfig{}); err == nil {
+		t.Error("expected error without url")
+	}
+}
@@ -0,0 +1,127 @@
+// Package boltbucket provides a bbolt bucket whose entries expire, shared by
+// the stores that need to survive restarts
+package boltbucket
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/starline/rabbitmq-worker/internal/logging"
+)
+
+// sweepInterval is how often expired entries are removed
+const sweepInterval = 10 * time.Minute
+
+// Bucket is a bucket of an embedded bbolt database. Entries whose time is
+// before the cutoff are removed in the background.
+type Bucket struct {
+	db   *bolt.DB
+	name []byte
+	// timeOf returns the time an entry expires from, false for entries
+	// that can't be read and are removed
+	timeOf func(value []byte) (time.Time, bool)
+	cutoff func() time.Time
+	done   chan struct{}
+}
+
+// Open opens or creates the database at path with the named bucket and
+// starts removing entries whose timeOf is before cutoff
+func Open(path, name string, timeOf func(value []byte) (time.Time, bool), cutoff func() time.Time) (*Bucket, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(name))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create bucket %s: %w", name, err)
+	}
+
+	b := &Bucket{
+		db:     db,
+		name:   []byte(name),
+		timeOf: timeOf,
+		cutoff: cutoff,
+		done:   make(chan struct{}),
+	}
+	go b.sweepLoop()
+	return b, nil
+}
+
+// Get returns a copy of the value of key, nil if there is none
+func (b *Bucket) Get(key string) ([]byte, error) {
+	var value []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(b.name).Get([]byte(key)); v != nil {
+			value = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	return value, err
+}
+
+// Put sets the value of key
+func (b *Bucket) Put(key string, value []byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(b.name).Put([]byte(key), value)
+	})
+}
+
+// Close stops the background sweep and closes the database
+func (b *Bucket) Close() error {
+	close(b.done)
+	return b.db.Close()
+}
+
+// sweepLoop periodically removes expired entries
+func (b *Bucket) sweepLoop() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			if err := b.Sweep(); err != nil {
+				logging.Error("failed to sweep expired entries", err, logrus.Fields{
+					"bucket": string(b.name),
+				})
+			}
+		}
+	}
+}
+
+// Sweep removes entries whose time is before the cutoff
+func (b *Bucket) Sweep() error {
+	cutoff := b.cutoff()
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(b.name)
+
+		// Deleting while iterating with a cursor skips keys, so collect first
+		var expired [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			if t, ok := b.timeOf(v); !ok || t.Before(cutoff) {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,55 @@
+package boltbucket
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// rfc3339Time decodes values written as RFC 3339 times
+func rfc3339Time(v []byte) (time.Time, bool) {
+	t, err := time.Parse(time.RFC3339, string(v))
+	return t, err == nil
+}
+
+func TestBucket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	now := time.Now()
+	cutoff := now.Add(-time.Hour)
+
+	b, err := Open(path, "test", rfc3339Time, func() time.Time { return cutoff })
+	if err != nil {
+		t.Fatalf("failed to open bucket: %v", err)
+	}
+	defer b.Close()
+
+	entries := map[string]string{
+		"old":     now.Add(-2 * time.Hour).Format(time.RFC3339),
+		"new":     now.Format(time.RFC3339),
+		"garbage": "not a time",
+	}
+	for k, v := range entries {
+		if err := b.Put(k, []byte(v)); err != nil {
+			t.Fatalf("failed to put %s: %v", k, err)
+		}
+	}
+
+	if v, err := b.Get("new"); err != nil || string(v) != entries["new"] {
+		t.Errorf("expected value of 'new', got %q, %v", v, err)
+	}
+	if v, err := b.Get("missing"); err != nil || v != nil {
+		t.Errorf("expected no value for missing key, got %q, %v", v, err)
+	}
+
+	if err := b.Sweep(); err != nil {
+		t.Fatalf("failed to sweep: %v", err)
+	}
+	for _, k := range []string{"old", "garbage"} {
+		if v, _ := b.Get(k); v != nil {
+			t.Errorf("expected %s to be swept", k)
+		}
+	}
+	if v, _ := b.Get("new"); v == nil {
+		t.Error("expected 'new' to be kept")
+	}
+}
@@ -4,16 +4,27 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/starline/rabbitmq-worker/internal/phone"
+	"github.com/starline/rabbitmq-worker/internal/translit"
 )
 
 // Config holds all configuration for the application
 type Config struct {
-	RabbitMQ RabbitMQConfig `yaml:"rabbitmq"`
-	API      APIConfig      `yaml:"api"`
-	Server   ServerConfig   `yaml:"server"`
-	Logging  LoggingConfig  `yaml:"logging"`
+	RabbitMQ  RabbitMQConfig  `yaml:"rabbitmq"`
+	API       APIConfig       `yaml:"api"`
+	Server    ServerConfig    `yaml:"server"`
+	Logging   LoggingConfig   `yaml:"logging"`
+	Dedup     DedupConfig     `yaml:"dedup"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	Phone     PhoneConfig     `yaml:"phone"`
+	SMS       SMSConfig       `yaml:"sms"`
+	Templates TemplatesConfig `yaml:"templates"`
+	Expiry    ExpiryConfig    `yaml:"expiry"`
+	DLR       DLRConfig       `yaml:"dlr"`
 }
 
 // RabbitMQConfig holds RabbitMQ connection settings
@@ -23,14 +34,106 @@ type RabbitMQConfig struct {
 	User     string `yaml:"user"`
 	Password string `yaml:"password"`
 	Queue    string `yaml:"queue"`
+
+	// Concurrency is the number of goroutines handling deliveries in parallel
+	Concurrency int `yaml:"concurrency"`
+	// Prefetch is the AMQP QoS prefetch count, defaults to Concurrency
+	Prefetch int `yaml:"prefetch"`
+	// ShutdownTimeout limits how long in-flight deliveries are awaited on shutdown
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// MaxPriority declares the main and retry queues with x-max-priority so
+	// that message priorities are honoured. Zero keeps plain queues. Existing
+	// queues must be deleted to change it, RabbitMQ refuses to redeclare them.
+	MaxPriority int `yaml:"max_priority"`
+
+	Retry     RetryConfig     `yaml:"retry"`
+	Reconnect ReconnectConfig `yaml:"reconnect"`
+	Events    EventsConfig    `yaml:"events"`
+}
+
+// RetryConfig holds settings for redelivering failed messages
+type RetryConfig struct {
+	// MaxAttempts is the total number of delivery attempts before dead-lettering
+	MaxAttempts int `yaml:"max_attempts"`
+	// Delays are the waits before each retry, the last one is reused
+	Delays []time.Duration `yaml:"delays"`
+	// Exchange is the dead-letter exchange, defaults to "<queue>.dlx"
+	Exchange string `yaml:"exchange"`
+	// DeadQueue receives messages that ran out of attempts, defaults to "<queue>.dead"
+	DeadQueue string `yaml:"dead_queue"`
+	// InvalidQueue receives payloads that failed schema validation, defaults
+	// to "<queue>.invalid"
+	InvalidQueue string `yaml:"invalid_queue"`
+}
+
+// EventsConfig holds settings for publishing message status events
+type EventsConfig struct {
+	// Exchange is the topic exchange events are published to, empty
+	// disables events
+	Exchange string `yaml:"exchange"`
+	// RoutingKeyPrefix is followed by the status in routing keys, defaults
+	// to "sms.status"
+	RoutingKeyPrefix string `yaml:"routing_key_prefix"`
+	// ConfirmTimeout limits the wait for the broker to confirm an event
+	ConfirmTimeout time.Duration `yaml:"confirm_timeout"`
 }
 
 // APIConfig holds API settings
 type APIConfig struct {
+	// Type selects the provider implementation, "zagruzka" by default
+	Type string `yaml:"type"`
+	// Name identifies the provider in logs and metrics, defaults to Type
+	Name string `yaml:"name"`
+
 	URL       string `yaml:"url"`
 	ServiceID string `yaml:"service_id"`
 	Pass      string `yaml:"pass"`
 	Source    string `yaml:"source"`
+
+	// Encoding is how the zagruzka provider sends parameters: query (the
+	// default), form_body or json_body
+	Encoding string `yaml:"encoding"`
+	// Auth sends the password in a header instead of the parameters: basic
+	// uses service_id and pass as credentials, bearer uses pass as the token
+	Auth string `yaml:"auth"`
+
+	// Response is the format of the gateway's success responses: zagruzka,
+	// json or none. Defaults to the provider's native format.
+	Response string `yaml:"response"`
+
+	// Headers are added to every request of the webhook provider
+	Headers map[string]string `yaml:"headers"`
+
+	// Priority orders providers for failover, lower values are tried first
+	Priority int `yaml:"priority"`
+	// Weight splits traffic between providers of the same priority
+	Weight int `yaml:"weight"`
+
+	Breaker BreakerConfig `yaml:"breaker"`
+
+	// Providers lists the gateways to route messages through. When empty the
+	// settings above describe the only provider.
+	Providers []APIConfig `yaml:"providers"`
+
+	// Timeout limits a single API request
+	Timeout time.Duration `yaml:"timeout"`
+	// MessageDeadline limits processing of a whole delivery, zero means no limit
+	MessageDeadline time.Duration `yaml:"message_deadline"`
+}
+
+// BreakerConfig holds circuit breaker settings of a provider
+type BreakerConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// FailureRatio of requests within Window that opens the breaker
+	FailureRatio float64 `yaml:"failure_ratio"`
+	// MinRequests within Window before the ratio is considered
+	MinRequests int `yaml:"min_requests"`
+	// Window is the period failures are counted over
+	Window time.Duration `yaml:"window"`
+	// OpenTimeout is how long the breaker stays open before trial requests
+	OpenTimeout time.Duration `yaml:"open_timeout"`
+	// HalfOpenRequests is the number of concurrent trial requests
+	HalfOpenRequests int `yaml:"half_open_requests"`
 }
 
 // ServerConfig holds server settings
@@ -45,6 +148,107 @@ type LoggingConfig struct {
 	Format string `yaml:"format"`
 }
 
+// ReconnectConfig holds backoff settings for reconnecting to RabbitMQ
+type ReconnectConfig struct {
+	// InitialDelay is the wait before the first retry, doubled on every attempt
+	InitialDelay time.Duration `yaml:"initial_delay"`
+	// MaxDelay caps the wait between attempts
+	MaxDelay time.Duration `yaml:"max_delay"`
+	// MaxAttempts stops reconnecting after this many failures, zero retries forever
+	MaxAttempts int `yaml:"max_attempts"`
+}
+
+// DedupConfig holds settings for skipping messages that were already sent
+type DedupConfig struct {
+	// Backend is "memory", "bolt" or "none"
+	Backend string `yaml:"backend"`
+	// Window is how long a sent message is remembered
+	Window time.Duration `yaml:"window"`
+	// MaxEntries caps the number of keys kept by the memory backend
+	MaxEntries int `yaml:"max_entries"`
+	// Path is the database file of the bolt backend
+	Path string `yaml:"path"`
+}
+
+// ProviderConfigs returns the configured providers, or the API settings
+// themselves when no list is given
+func (a *APIConfig) ProviderConfigs() []APIConfig {
+	if len(a.Providers) == 0 {
+		return []APIConfig{*a}
+	}
+	return a.Providers
+}
+
+// RateLimitConfig holds outbound rate limits, zero values disable a limit
+type RateLimitConfig struct {
+	// GlobalRate is the number of sends per second across all recipients
+	GlobalRate float64 `yaml:"global_rate"`
+	// GlobalBurst is the number of sends allowed at once
+	GlobalBurst int `yaml:"global_burst"`
+	// RecipientMax is the number of sends to one recipient within RecipientWindow
+	RecipientMax    int           `yaml:"recipient_max"`
+	RecipientWindow time.Duration `yaml:"recipient_window"`
+	// Policy for over-limit messages: "delay" or "reject"
+	Policy string `yaml:"policy"`
+}
+
+// PhoneConfig holds recipient number validation settings
+type PhoneConfig struct {
+	// DefaultCountry is the ISO code national numbers are interpreted in
+	DefaultCountry string `yaml:"default_country"`
+}
+
+// SMSConfig holds message body settings
+type SMSConfig struct {
+	// MaxSegments is the largest number of SMS parts a message may be split
+	// into, zero allows any length
+	MaxSegments int `yaml:"max_segments"`
+	// Transliterate is the default transliteration policy: never, always
+	// or if_saves_segments
+	Transliterate string `yaml:"transliterate"`
+	// TransliterateSources overrides the policy for messages published by
+	// the application with the given AMQP app-id
+	TransliterateSources map[string]string `yaml:"transliterate_sources"`
+}
+
+// TemplatesConfig holds message template settings
+type TemplatesConfig struct {
+	// Dir holds template files named "<name>.<locale>.tmpl"
+	Dir string `yaml:"dir"`
+	// DefaultLocale is used when a message has no locale or the template has
+	// no variant for it
+	DefaultLocale string `yaml:"default_locale"`
+	// Items holds templates by name and locale
+	Items map[string]map[string]string `yaml:"items"`
+}
+
+// ExpiryConfig holds settings for dropping stale messages
+type ExpiryConfig struct {
+	// MaxAge limits the age of a message by its type, the "default" entry
+	// applies to other types. Zero or missing means no limit.
+	MaxAge map[string]time.Duration `yaml:"max_age"`
+}
+
+// DLRConfig holds settings for receiving delivery reports
+type DLRConfig struct {
+	// Path is served on the metrics port and receives the reports, "/dlr"
+	// by default
+	Path string `yaml:"path"`
+	// Token, when set, must be sent by the provider in the token parameter
+	// or the X-DLR-Token header
+	Token string `yaml:"token"`
+	// Backend is "memory" or "bolt". The memory backend only matches reports
+	// received by the replica that sent the message, and forgets messages on
+	// restart.
+	Backend string `yaml:"backend"`
+	// Window is how long a sent message waits for its report
+	Window time.Duration `yaml:"window"`
+	// MaxEntries caps the number of messages kept by the memory backend
+	MaxEntries int `yaml:"max_entries"`
+	// Database is the database file of the bolt backend
+	Database string `yaml:"database"`
+}
+
 // ConnectionString returns formatted RabbitMQ connection string
 func (r *RabbitMQConfig) ConnectionString() string {
 	return fmt.Sprintf("amqp://%s:%s@%s:%d/", r.User, r.Password, r.Host, r.Port)
@@ -66,5 +270,161 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
+	config.setDefaults()
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
 	return &config, nil
-}
\ No newline at end of file
+}
+
+// setDefaults fills in optional settings that were left empty
+func (c *Config) setDefaults() {
+	if c.RabbitMQ.Concurrency <= 0 {
+		c.RabbitMQ.Concurrency = 1
+	}
+	if c.RabbitMQ.Prefetch <= 0 {
+		c.RabbitMQ.Prefetch = c.RabbitMQ.Concurrency
+	}
+
+	if c.RabbitMQ.ShutdownTimeout <= 0 {
+		c.RabbitMQ.ShutdownTimeout = 30 * time.Second
+	}
+
+	retry := &c.RabbitMQ.Retry
+	if len(retry.Delays) == 0 {
+		retry.Delays = []time.Duration{5 * time.Second, 30 * time.Second, 5 * time.Minute}
+	}
+	if retry.MaxAttempts <= 0 {
+		retry.MaxAttempts = len(retry.Delays) + 1
+	}
+	if retry.Exchange == "" {
+		retry.Exchange = c.RabbitMQ.Queue + ".dlx"
+	}
+	if retry.DeadQueue == "" {
+		retry.DeadQueue = c.RabbitMQ.Queue + ".dead"
+	}
+	if retry.InvalidQueue == "" {
+		retry.InvalidQueue = c.RabbitMQ.Queue + ".invalid"
+	}
+
+	if c.RabbitMQ.Events.RoutingKeyPrefix == "" {
+		c.RabbitMQ.Events.RoutingKeyPrefix = "sms.status"
+	}
+	if c.RabbitMQ.Events.ConfirmTimeout <= 0 {
+		c.RabbitMQ.Events.ConfirmTimeout = 5 * time.Second
+	}
+
+	if c.RabbitMQ.Reconnect.InitialDelay <= 0 {
+		c.RabbitMQ.Reconnect.InitialDelay = time.Second
+	}
+	if c.RabbitMQ.Reconnect.MaxDelay <= 0 {
+		c.RabbitMQ.Reconnect.MaxDelay = time.Minute
+	}
+
+	if c.API.Timeout <= 0 {
+		c.API.Timeout = 30 * time.Second
+	}
+	c.API.Breaker.setDefaults()
+	for i := range c.API.Providers {
+		p := &c.API.Providers[i]
+		if p.Breaker == (BreakerConfig{}) {
+			p.Breaker = c.API.Breaker
+		}
+		p.Breaker.setDefaults()
+		if p.Timeout <= 0 {
+			p.Timeout = c.API.Timeout
+		}
+		if p.Source == "" {
+			p.Source = c.API.Source
+		}
+		if p.Weight <= 0 {
+			p.Weight = 1
+		}
+	}
+
+	if c.SMS.Transliterate == "" {
+		c.SMS.Transliterate = "never"
+	}
+
+	if c.Templates.DefaultLocale == "" {
+		c.Templates.DefaultLocale = "ru"
+	}
+
+	if c.DLR.Path == "" {
+		c.DLR.Path = "/dlr"
+	}
+	if c.DLR.Backend == "" {
+		c.DLR.Backend = "memory"
+	}
+	if c.DLR.Window <= 0 {
+		c.DLR.Window = 72 * time.Hour
+	}
+	if c.DLR.MaxEntries <= 0 {
+		c.DLR.MaxEntries = 100000
+	}
+	if c.DLR.Database == "" {
+		c.DLR.Database = "dlr.db"
+	}
+
+	if c.Phone.DefaultCountry == "" {
+		c.Phone.DefaultCountry = "RU"
+	}
+
+	if c.RateLimit.Policy == "" {
+		c.RateLimit.Policy = "delay"
+	}
+	if c.Dedup.Backend == "" {
+		c.Dedup.Backend = "memory"
+	}
+	if c.Dedup.Window <= 0 {
+		c.Dedup.Window = time.Hour
+	}
+	if c.Dedup.MaxEntries <= 0 {
+		c.Dedup.MaxEntries = 100000
+	}
+	if c.Dedup.Path == "" {
+		c.Dedup.Path = "dedup.db"
+	}
+}
+
+// validate checks settings that can't be fixed by defaults
+func (c *Config) validate() error {
+	if c.RabbitMQ.MaxPriority < 0 || c.RabbitMQ.MaxPriority > 255 {
+		return fmt.Errorf("rabbitmq.max_priority must be between 0 and 255, got %d", c.RabbitMQ.MaxPriority)
+	}
+	if !translit.IsPolicy(c.SMS.Transliterate) {
+		return fmt.Errorf("unknown sms.transliterate policy %q", c.SMS.Transliterate)
+	}
+	for source, policy := range c.SMS.TransliterateSources {
+		if !translit.IsPolicy(policy) {
+			return fmt.Errorf("unknown sms.transliterate_sources policy %q for %s", policy, source)
+		}
+	}
+	if !phone.IsCountry(c.Phone.DefaultCountry) {
+		return fmt.Errorf("unknown phone.default_country %q, national numbers can't be interpreted in it", c.Phone.DefaultCountry)
+	}
+	if c.RateLimit.Policy != "delay" && c.RateLimit.Policy != "reject" {
+		return fmt.Errorf("unknown rate_limit.policy %q, expected delay or reject", c.RateLimit.Policy)
+	}
+	return nil
+}
+
+// setDefaults fills in breaker thresholds that were left empty
+func (b *BreakerConfig) setDefaults() {
+	if b.FailureRatio <= 0 {
+		b.FailureRatio = 0.5
+	}
+	if b.MinRequests <= 0 {
+		b.MinRequests = 10
+	}
+	if b.Window <= 0 {
+		b.Window = time.Minute
+	}
+	if b.OpenTimeout <= 0 {
+		b.OpenTimeout = 30 * time.Second
+	}
+	if b.HalfOpenRequests <= 0 {
+		b.HalfOpenRequests = 1
+	}
+}
@@ -0,0 +1,212 @@
+package dlr
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/starline/rabbitmq-worker/internal/logging"
+	"github.com/starline/rabbitmq-worker/internal/metrics"
+)
+
+// tokenHeader carries the shared secret of a delivery report
+const tokenHeader = "X-DLR-Token"
+
+// maxReportSize limits the body of a delivery report
+const maxReportSize = 64 << 10
+
+// otherStatus labels reports with intermediate or unknown statuses in metrics
+const otherStatus = "other"
+
+// Fields a report's provider message ID and status are read from, in order
+var (
+	idFields     = []string{"message_id", "messageId", "msgid", "sms_id", "id"}
+	statusFields = []string{"status", "state", "stat", "dlr_status"}
+)
+
+// statuses maps provider status codes, lower-cased, to message statuses
+var statuses = map[string]string{
+	"delivered":     StatusDelivered,
+	"delivrd":       StatusDelivered,
+	"delivery":      StatusDelivered,
+	"ok":            StatusDelivered,
+	"undelivered":   StatusUndelivered,
+	"undeliv":       StatusUndelivered,
+	"undeliverable": StatusUndelivered,
+	"rejectd":       StatusUndelivered,
+	"rejected":      StatusUndelivered,
+	"failed":        StatusUndelivered,
+	"error":         StatusUndelivered,
+	"deleted":       StatusUndelivered,
+	"expired":       StatusExpired,
+}
+
+// Report is a delivery report received from a provider
+type Report struct {
+	ProviderMessageID string
+	// Status is the normalized status: delivered, undelivered or expired.
+	// It is empty for intermediate and unknown provider statuses.
+	Status string
+	// RawStatus is the status as sent by the provider
+	RawStatus string
+}
+
+// Handler receives delivery reports and updates the tracked messages
+type Handler struct {
+	tracker *Tracker
+	token   string
+}
+
+// NewHandler creates a delivery report handler. When token is not empty
+// reports must carry it in the token parameter or the X-DLR-Token header.
+func NewHandler(tracker *Tracker, token string) *Handler {
+	return &Handler{tracker: tracker, token: token}
+}
+
+// ServeHTTP accepts form encoded and JSON delivery reports. Reports for
+// unknown messages and with intermediate statuses, such as ENROUTE or
+// ACCEPTD, are acknowledged so the provider does not resend them.
+func (h *Handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		rw.Header().Set("Allow", "GET, POST")
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(rw, r.Body, maxReportSize)
+	params, err := reportParams(r)
+	if err != nil {
+		logging.Warn("malformed delivery report", logrus.Fields{"error": err.Error()})
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !h.authorized(r.Header.Get(tokenHeader), params["token"]) {
+		http.Error(rw, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	report, err := parseReport(params)
+	if err != nil {
+		logging.Warn("malformed delivery report", logrus.Fields{"error": err.Error()})
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fields := logrus.Fields{
+		"provider_message_id": report.ProviderMessageID,
+		"status":              report.Status,
+		"provider_status":     report.RawStatus,
+	}
+	if report.Status == "" {
+		metrics.DeliveryReports.WithLabelValues(otherStatus).Inc()
+		logging.Info("delivery report with intermediate status", fields)
+		rw.WriteHeader(http.StatusOK)
+		fmt.Fprintln(rw, "OK")
+		return
+	}
+	metrics.DeliveryReports.WithLabelValues(report.Status).Inc()
+
+	rec, ok, err := h.tracker.Update(report.ProviderMessageID, report.Status)
+	if err != nil {
+		// The provider resends the report when it isn't acknowledged
+		logging.Error("failed to update delivery status", err, fields)
+		http.Error(rw, "failed to update delivery status", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		metrics.DeliveryReportsUnmatched.Inc()
+		logging.Warn("delivery report for unknown message", fields)
+	} else {
+		fields["message_id"] = rec.MessageID
+		fields["id"] = rec.ID
+		fields["recipient"] = rec.Recipient
+		fields["provider"] = rec.Provider
+		logging.Info("delivery report received", fields)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	fmt.Fprintln(rw, "OK")
+}
+
+// authorized reports whether one of the tokens a report carries matches the
+// configured one. Tokens are compared in constant time.
+func (h *Handler) authorized(tokens ...string) bool {
+	if h.token == "" {
+		return true
+	}
+	ok := 0
+	for _, token := range tokens {
+		ok |= subtle.ConstantTimeCompare([]byte(token), []byte(h.token))
+	}
+	return ok == 1
+}
+
+// reportParams reads the parameters of a report from a JSON body, or from
+// the query and form body
+func reportParams(r *http.Request) (map[string]string, error) {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != "application/json" && !strings.HasSuffix(contentType, "+json") {
+		if err := r.ParseForm(); err != nil {
+			return nil, fmt.Errorf("failed to parse report: %w", err)
+		}
+		params := make(map[string]string, len(r.Form))
+		for key := range r.Form {
+			params[key] = r.Form.Get(key)
+		}
+		return params, nil
+	}
+
+	var body map[string]any
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to parse report: %w", err)
+	}
+
+	params := make(map[string]string, len(body))
+	for key, value := range r.URL.Query() {
+		params[key] = value[0]
+	}
+	for key, value := range body {
+		switch v := value.(type) {
+		case string:
+			params[key] = v
+		case json.Number:
+			params[key] = v.String()
+		}
+	}
+	return params, nil
+}
+
+// parseReport extracts the message ID and status from report parameters.
+// Statuses that aren't final leave the report status empty.
+func parseReport(params map[string]string) (Report, error) {
+	report := Report{
+		ProviderMessageID: firstField(params, idFields),
+		RawStatus:         firstField(params, statusFields),
+	}
+	if report.ProviderMessageID == "" {
+		return Report{}, fmt.Errorf("report has no message id")
+	}
+	if report.RawStatus == "" {
+		return Report{}, fmt.Errorf("report has no status")
+	}
+
+	report.Status = statuses[strings.ToLower(report.RawStatus)]
+	return report, nil
+}
+
+// firstField returns the first non-empty parameter of the given names
+func firstField(params map[string]string, names []string) string {
+	for _, name := range names {
+		if value := strings.TrimSpace(params[name]); value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,155 @@
+package dlr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		body        string
+		wantCode    int
+		wantStatus  string
+	}{
+		{
+			name:        "form",
+			method:      http.MethodPost,
+			target:      "/dlr",
+			contentType: "application/x-www-form-urlencoded",
+			body:        url.Values{"msgid": {"p1"}, "stat": {"DELIVRD"}}.Encode(),
+			wantCode:    http.StatusOK,
+			wantStatus:  StatusDelivered,
+		},
+		{
+			name:       "query",
+			method:     http.MethodGet,
+			target:     "/dlr?message_id=p1&status=UNDELIV",
+			wantCode:   http.StatusOK,
+			wantStatus: StatusUndelivered,
+		},
+		{
+			name:        "json",
+			method:      http.MethodPost,
+			target:      "/dlr",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"messageId":"p1","state":"expired"}`,
+			wantCode:    http.StatusOK,
+			wantStatus:  StatusExpired,
+		},
+		{
+			name:        "numeric json id",
+			method:      http.MethodPost,
+			target:      "/dlr",
+			contentType: "application/json",
+			body:        `{"id":123,"status":"delivered"}`,
+			wantCode:    http.StatusOK,
+		},
+		{
+			name:        "unknown message",
+			method:      http.MethodPost,
+			target:      "/dlr",
+			contentType: "application/json",
+			body:        `{"id":"other","status":"delivered"}`,
+			wantCode:    http.StatusOK,
+			wantStatus:  StatusSent,
+		},
+		{
+			name:        "missing id",
+			method:      http.MethodPost,
+			target:      "/dlr",
+			contentType: "application/json",
+			body:        `{"status":"delivered"}`,
+			wantCode:    http.StatusBadRequest,
+			wantStatus:  StatusSent,
+		},
+		{
+			name:        "intermediate status",
+			method:      http.MethodPost,
+			target:      "/dlr",
+			contentType: "application/json",
+			body:        `{"id":"p1","status":"ENROUTE"}`,
+			wantCode:    http.StatusOK,
+			wantStatus:  StatusSent,
+		},
+		{
+			name:       "unknown status",
+			method:     http.MethodGet,
+			target:     "/dlr?id=p1&stat=UNKNOWN",
+			wantCode:   http.StatusOK,
+			wantStatus: StatusSent,
+		},
+		{
+			name:        "malformed json",
+			method:      http.MethodPost,
+			target:      "/dlr",
+			contentType: "application/json",
+			body:        `{`,
+			wantCode:    http.StatusBadRequest,
+			wantStatus:  StatusSent,
+		},
+		{
+			name:       "wrong method",
+			method:     http.MethodPut,
+			target:     "/dlr?id=p1&status=delivered",
+			wantCode:   http.StatusMethodNotAllowed,
+			wantStatus: StatusSent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := NewTracker(time.Hour, 10)
+			tracker.Track(Record{ProviderMessageID: "p1"})
+			tracker.Track(Record{ProviderMessageID: "123"})
+
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			NewHandler(tracker, "").ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("expected code %d, got %d: %s", tt.wantCode, rec.Code, rec.Body.String())
+			}
+			if tt.wantStatus == "" {
+				return
+			}
+			if got, _, _ := tracker.Get("p1"); got.Status != tt.wantStatus {
+				t.Errorf("expected status %s, got %s", tt.wantStatus, got.Status)
+			}
+		})
+	}
+}
+
+func TestHandlerToken(t *testing.T) {
+	tracker := NewTracker(time.Hour, 10)
+	tracker.Track(Record{ProviderMessageID: "p1"})
+	handler := NewHandler(tracker, "secret")
+
+	req := httptest.NewRequest(http.MethodGet, "/dlr?id=p1&status=delivered&token=wrong", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected wrong token to be rejected, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/dlr?id=p1&status=delivered", nil)
+	req.Header.Set(tokenHeader, "secret")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected token header to be accepted, got %d", rec.Code)
+	}
+	if got, _, _ := tracker.Get("p1"); got.Status != StatusDelivered {
+		t.Errorf("expected message to be delivered, got %s", got.Status)
+	}
+}
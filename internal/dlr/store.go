@@ -0,0 +1,85 @@
+package dlr
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+
+	"github.com/starline/rabbitmq-worker/internal/config"
+)
+
+// Store keeps the records of sent messages by provider message ID
+type Store interface {
+	// Get returns the record of a provider message ID
+	Get(providerMessageID string) (Record, bool, error)
+	// Put saves a record, replacing the one with the same provider message ID
+	Put(rec Record) error
+	// Close releases resources held by the store
+	Close() error
+}
+
+// OpenStore creates the store selected by the configuration
+func OpenStore(cfg *config.DLRConfig) (Store, error) {
+	switch cfg.Backend {
+	case "memory":
+		return NewMemoryStore(cfg.MaxEntries), nil
+	case "bolt":
+		return OpenBoltStore(cfg.Database, cfg.Window)
+	default:
+		return nil, fmt.Errorf("unknown delivery report backend %q", cfg.Backend)
+	}
+}
+
+// MemoryStore keeps records in memory, forgetting the least recently sent
+// messages when full
+type MemoryStore struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List
+}
+
+// NewMemoryStore creates an in-memory store keeping up to maxEntries records
+func NewMemoryStore(maxEntries int) *MemoryStore {
+	return &MemoryStore{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// Get returns the record of a provider message ID
+func (s *MemoryStore) Get(providerMessageID string) (Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.entries[providerMessageID]
+	if !ok {
+		return Record{}, false, nil
+	}
+	return el.Value.(Record), true, nil
+}
+
+// Put saves a record. A new record counts as the most recently sent one.
+func (s *MemoryStore) Put(rec Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.entries[rec.ProviderMessageID]; ok {
+		el.Value = rec
+		return nil
+	}
+
+	s.entries[rec.ProviderMessageID] = s.order.PushFront(rec)
+	for s.maxEntries > 0 && s.order.Len() > s.maxEntries {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(Record).ProviderMessageID)
+	}
+	return nil
+}
+
+// Close releases resources held by the store
+func (s *MemoryStore) Close() error {
+	return nil
+}
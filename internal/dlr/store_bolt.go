@@ -0,0 +1,78 @@
+package dlr
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/starline/rabbitmq-worker/internal/boltbucket"
+)
+
+// BoltStore keeps records in an embedded bbolt database so reports for
+// messages sent before a restart can still be matched
+type BoltStore struct {
+	bucket *boltbucket.Bucket
+	window time.Duration
+	now    func() time.Time
+}
+
+// OpenBoltStore opens or creates the database at path and starts removing
+// records of messages sent more than window ago in the background
+func OpenBoltStore(path string, window time.Duration) (*BoltStore, error) {
+	s := &BoltStore{
+		window: window,
+		now:    time.Now,
+	}
+	bucket, err := boltbucket.Open(path, "records", sentAt, s.cutoff)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open delivery report database: %w", err)
+	}
+	s.bucket = bucket
+	return s, nil
+}
+
+// Get returns the record of a provider message ID
+func (s *BoltStore) Get(providerMessageID string) (Record, bool, error) {
+	v, err := s.bucket.Get(providerMessageID)
+	if err != nil || v == nil {
+		return Record{}, false, err
+	}
+	var rec Record
+	if err := json.Unmarshal(v, &rec); err != nil {
+		return Record{}, false, err
+	}
+	return rec, true, nil
+}
+
+// Put saves a record
+func (s *BoltStore) Put(rec Record) error {
+	v, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return s.bucket.Put(rec.ProviderMessageID, v)
+}
+
+// Close stops the background sweep and closes the database
+func (s *BoltStore) Close() error {
+	return s.bucket.Close()
+}
+
+// sweep removes records of messages sent before the window
+func (s *BoltStore) sweep() error {
+	return s.bucket.Sweep()
+}
+
+// cutoff returns the time records of messages sent before are removed
+func (s *BoltStore) cutoff() time.Time {
+	return s.now().Add(-s.window)
+}
+
+// sentAt decodes the time the message of a record was sent
+func sentAt(v []byte) (time.Time, bool) {
+	var rec Record
+	if err := json.Unmarshal(v, &rec); err != nil {
+		return time.Time{}, false
+	}
+	return rec.SentAt, true
+}
@@ -0,0 +1,145 @@
+package dlr
+
+import (
+	"sync"
+	"time"
+
+	"github.com/starline/rabbitmq-worker/internal/config"
+)
+
+// Message statuses
+const (
+	// StatusSent is the status of a message accepted by the provider that
+	// has no delivery report yet
+	StatusSent        = "sent"
+	StatusDelivered   = "delivered"
+	StatusUndelivered = "undelivered"
+	StatusExpired     = "expired"
+)
+
+// Record is a sent message waiting for or having received its delivery report
+type Record struct {
+	// ProviderMessageID is the provider's ID the report refers to
+	ProviderMessageID string
+	Provider          string
+	// MessageID and CorrelationID are the AMQP properties of the delivery
+	MessageID     string
+	CorrelationID string
+	// ID is the id field of the message, if it had one
+	ID        string
+	Type      string
+	Recipient string
+	Status    string
+	SentAt    time.Time
+	UpdatedAt time.Time
+}
+
+// Tracker remembers sent messages by provider message ID so delivery reports
+// can be matched to them. Messages are forgotten once their window has passed
+// or the store drops them.
+type Tracker struct {
+	// mu serializes updates so concurrent reports for a message don't
+	// overwrite each other
+	mu        sync.Mutex
+	store     Store
+	window    time.Duration
+	observers []func(Record)
+	now       func() time.Time
+}
+
+// NewTracker creates a tracker remembering up to maxEntries messages in
+// memory for window
+func NewTracker(window time.Duration, maxEntries int) *Tracker {
+	return NewStoreTracker(NewMemoryStore(maxEntries), window)
+}
+
+// NewStoreTracker creates a tracker keeping messages in store for window
+func NewStoreTracker(store Store, window time.Duration) *Tracker {
+	return &Tracker{
+		store:  store,
+		window: window,
+		now:    time.Now,
+	}
+}
+
+// OpenTracker creates a tracker with the store selected by the configuration
+func OpenTracker(cfg *config.DLRConfig) (*Tracker, error) {
+	store, err := OpenStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewStoreTracker(store, cfg.Window), nil
+}
+
+// OnUpdate registers fn to be called with every record updated by a report
+func (t *Tracker) OnUpdate(fn func(Record)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.observers = append(t.observers, fn)
+}
+
+// Track starts waiting for the delivery report of a sent message
+func (t *Tracker) Track(rec Record) error {
+	if rec.ProviderMessageID == "" {
+		return nil
+	}
+
+	now := t.now()
+	if rec.SentAt.IsZero() {
+		rec.SentAt = now
+	}
+	if rec.Status == "" {
+		rec.Status = StatusSent
+	}
+	rec.UpdatedAt = now
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.store.Put(rec)
+}
+
+// Get returns the record of a provider message ID
+func (t *Tracker) Get(providerMessageID string) (Record, bool, error) {
+	return t.lookup(providerMessageID)
+}
+
+// Update sets the status of a tracked message and notifies observers. It
+// returns false if the message is unknown.
+func (t *Tracker) Update(providerMessageID, status string) (Record, bool, error) {
+	t.mu.Lock()
+	rec, ok, err := t.lookup(providerMessageID)
+	if err != nil || !ok {
+		t.mu.Unlock()
+		return Record{}, false, err
+	}
+	rec.Status = status
+	rec.UpdatedAt = t.now()
+	if err := t.store.Put(rec); err != nil {
+		t.mu.Unlock()
+		return Record{}, false, err
+	}
+	observers := t.observers
+	t.mu.Unlock()
+
+	for _, fn := range observers {
+		fn(rec)
+	}
+	return rec, true, nil
+}
+
+// Close releases resources held by the store
+func (t *Tracker) Close() error {
+	return t.store.Close()
+}
+
+// lookup returns the record of an ID unless it is outside the window
+func (t *Tracker) lookup(providerMessageID string) (Record, bool, error) {
+	rec, ok, err := t.store.Get(providerMessageID)
+	if err != nil || !ok {
+		return Record{}, false, err
+	}
+	if t.window > 0 && t.now().Sub(rec.SentAt) >= t.window {
+		return Record{}, false, nil
+	}
+	return rec, true, nil
+}
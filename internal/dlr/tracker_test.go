@@ -0,0 +1,130 @@
+package dlr
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/starline/rabbitmq-worker/internal/config"
+)
+
+func TestTrackerUpdate(t *testing.T) {
+	tracker := NewTracker(time.Hour, 10)
+
+	var updates []Record
+	tracker.OnUpdate(func(rec Record) {
+		updates = append(updates, rec)
+	})
+
+	tracker.Track(Record{ProviderMessageID: "p1", MessageID: "m1", Recipient: "79218897127"})
+	tracker.Track(Record{MessageID: "no-provider-id"})
+
+	rec, ok, err := tracker.Get("p1")
+	if err != nil || !ok || rec.Status != StatusSent || rec.SentAt.IsZero() {
+		t.Fatalf("expected tracked message with status sent, got %+v %v %v", rec, ok, err)
+	}
+
+	rec, ok, err = tracker.Update("p1", StatusDelivered)
+	if err != nil || !ok || rec.Status != StatusDelivered || rec.MessageID != "m1" {
+		t.Errorf("unexpected update result %+v %v %v", rec, ok, err)
+	}
+	if len(updates) != 1 || updates[0].Status != StatusDelivered {
+		t.Errorf("expected observer to be notified once, got %+v", updates)
+	}
+
+	if _, ok, _ := tracker.Update("unknown", StatusDelivered); ok {
+		t.Error("expected unknown message not to be updated")
+	}
+	if len(updates) != 1 {
+		t.Errorf("expected no notification for unknown message, got %+v", updates)
+	}
+}
+
+func TestTrackerWindow(t *testing.T) {
+	now := time.Now()
+	tracker := NewTracker(time.Hour, 10)
+	tracker.now = func() time.Time { return now }
+
+	tracker.Track(Record{ProviderMessageID: "p1"})
+
+	now = now.Add(59 * time.Minute)
+	if _, ok, _ := tracker.Get("p1"); !ok {
+		t.Error("expected message to be tracked within the window")
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok, _ := tracker.Update("p1", StatusDelivered); ok {
+		t.Error("expected message to be forgotten after the window")
+	}
+}
+
+func TestTrackerMaxEntries(t *testing.T) {
+	tracker := NewTracker(time.Hour, 2)
+
+	tracker.Track(Record{ProviderMessageID: "p1"})
+	tracker.Track(Record{ProviderMessageID: "p2"})
+	tracker.Track(Record{ProviderMessageID: "p3"})
+
+	if _, ok, _ := tracker.Get("p1"); ok {
+		t.Error("expected oldest message to be evicted")
+	}
+	for _, id := range []string{"p2", "p3"} {
+		if _, ok, _ := tracker.Get(id); !ok {
+			t.Errorf("expected %s to be tracked", id)
+		}
+	}
+}
+
+func TestBoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dlr.db")
+
+	store, err := OpenBoltStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	tracker := NewStoreTracker(store, time.Hour)
+	if err := tracker.Track(Record{ProviderMessageID: "p1", MessageID: "m1"}); err != nil {
+		t.Fatalf("failed to track message: %v", err)
+	}
+	tracker.Close()
+
+	// Messages survive reopening the database
+	store, err = OpenBoltStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	tracker = NewStoreTracker(store, time.Hour)
+
+	rec, ok, err := tracker.Update("p1", StatusDelivered)
+	if err != nil || !ok || rec.MessageID != "m1" {
+		t.Fatalf("expected message to be matched after reopening, got %+v %v %v", rec, ok, err)
+	}
+	if rec, _, _ := tracker.Get("p1"); rec.Status != StatusDelivered {
+		t.Errorf("expected status to be saved, got %s", rec.Status)
+	}
+
+	store.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if err := store.sweep(); err != nil {
+		t.Fatalf("failed to sweep: %v", err)
+	}
+	if _, ok, _ := store.Get("p1"); ok {
+		t.Error("expected expired record to be swept")
+	}
+}
+
+func TestOpenTracker(t *testing.T) {
+	if _, err := OpenTracker(&config.DLRConfig{Backend: "redis"}); err == nil {
+		t.Error("expected error for unknown backend")
+	}
+
+	tracker, err := OpenTracker(&config.DLRConfig{Backend: "memory", Window: time.Hour})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer tracker.Close()
+	tracker.Track(Record{ProviderMessageID: "p1"})
+	if _, ok, _ := tracker.Get("p1"); !ok {
+		t.Error("expected message to be tracked")
+	}
+}
@@ -1,13 +1,26 @@
 package metrics
 
 import (
+	"encoding/json"
 	"net/http"
+	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// Message outcomes used as label values of MessageOutcomes
+const (
+	OutcomeSent      = "sent"
+	OutcomeFailed    = "failed"
+	OutcomeDuplicate = "duplicate"
+	OutcomeThrottled = "throttled"
+	OutcomeInvalid   = "invalid"
+	OutcomeTooLong   = "too_long"
+	OutcomeExpired   = "expired"
+)
+
 var (
 	// MessagesReceived counts total messages received from RabbitMQ
 	MessagesReceived = promauto.NewCounter(prometheus.CounterOpts{
@@ -21,6 +34,75 @@ var (
 		Help: "The total number of messages processed successfully",
 	})
 
+	// MessagesInFlight tracks deliveries currently being processed
+	MessagesInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "messages_in_flight",
+		Help: "The number of deliveries currently being processed",
+	})
+
+	// MessageOutcomes counts individual SMS messages by outcome
+	MessageOutcomes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sms_messages_total",
+		Help: "The total number of SMS messages by outcome",
+	}, []string{"outcome"})
+
+	// MessageSegments tracks how many SMS parts sent messages take
+	MessageSegments = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sms_message_segments",
+		Help:    "The number of SMS segments per sent message",
+		Buckets: prometheus.LinearBuckets(1, 1, 10),
+	}, []string{"encoding"})
+
+	// MessagesMalformed counts deliveries whose payload failed validation
+	MessagesMalformed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rabbitmq_messages_malformed_total",
+		Help: "The total number of deliveries with an invalid payload by reason",
+	}, []string{"reason"})
+
+	// DeliveryReports counts delivery reports received from providers by status
+	DeliveryReports = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sms_delivery_reports_total",
+		Help: "The total number of delivery reports by status",
+	}, []string{"status"})
+
+	// DeliveryReportsUnmatched counts delivery reports for unknown messages
+	DeliveryReportsUnmatched = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sms_delivery_reports_unmatched_total",
+		Help: "The total number of delivery reports that matched no sent message",
+	})
+
+	// StatusEvents counts message status events published to RabbitMQ
+	StatusEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sms_status_events_total",
+		Help: "The total number of message status events published by status and result",
+	}, []string{"status", "result"})
+
+	// Replies counts replies published to the ReplyTo queue of deliveries.
+	// Unroutable replies are also counted as published before RabbitMQ
+	// returns them.
+	Replies = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rabbitmq_replies_total",
+		Help: "The total number of replies published to producers by result",
+	}, []string{"result"})
+
+	// SendsThrottled counts sends held back by rate limits
+	SendsThrottled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sms_sends_throttled_total",
+		Help: "The total number of sends held back by rate limits",
+	}, []string{"scope", "action"})
+
+	// MessagesRetried counts messages scheduled for a delayed retry
+	MessagesRetried = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "messages_retried_total",
+		Help: "The total number of messages scheduled for retry",
+	})
+
+	// MessagesDeadLettered counts messages moved to the dead-letter queue
+	MessagesDeadLettered = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "messages_dead_lettered_total",
+		Help: "The total number of messages moved to the dead-letter queue",
+	})
+
 	// APIRequestsSent counts total API requests sent
 	APIRequestsSent = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "api_requests_sent_total",
@@ -39,6 +121,18 @@ var (
 		Help: "The total number of failed API requests",
 	})
 
+	// ProviderRequests counts requests per SMS provider by result
+	ProviderRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sms_provider_requests_total",
+		Help: "The total number of requests per SMS provider by result",
+	}, []string{"provider", "result"})
+
+	// ProviderFailovers counts sends moved away from a failing provider
+	ProviderFailovers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sms_provider_failovers_total",
+		Help: "The total number of failovers away from an SMS provider",
+	}, []string{"provider"})
+
 	// MessageProcessingDuration tracks message processing time
 	MessageProcessingDuration = promauto.NewHistogram(prometheus.HistogramOpts{
 		Name: "message_processing_duration_seconds",
@@ -53,6 +147,24 @@ var (
 		Buckets: prometheus.DefBuckets,
 	})
 
+	// RabbitMQReconnectAttempts counts attempts to reconnect to RabbitMQ
+	RabbitMQReconnectAttempts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "rabbitmq_reconnect_attempts_total",
+		Help: "The total number of attempts to reconnect to RabbitMQ",
+	})
+
+	// RabbitMQLastConnected holds the time of the last successful connection
+	RabbitMQLastConnected = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "rabbitmq_last_connected_timestamp_seconds",
+		Help: "Unix time of the last successful connection to RabbitMQ",
+	})
+
+	// CircuitState reports the circuit breaker state of each SMS provider
+	CircuitState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sms_provider_circuit_state",
+		Help: "Circuit breaker state per SMS provider: 0 closed, 1 half-open, 2 open",
+	}, []string{"provider"})
+
 	// WorkerHealthy indicates if worker is healthy (1) or not (0)
 	WorkerHealthy = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "worker_healthy",
@@ -60,15 +172,66 @@ var (
 	})
 )
 
+// Circuit breaker states
+const (
+	CircuitClosed   = "closed"
+	CircuitHalfOpen = "half-open"
+	CircuitOpen     = "open"
+)
+
+var circuitStateValues = map[string]float64{
+	CircuitClosed:   0,
+	CircuitHalfOpen: 1,
+	CircuitOpen:     2,
+}
+
+var (
+	circuitsMu sync.RWMutex
+	circuits   = map[string]string{}
+)
+
+// SetCircuitState records the circuit breaker state of a provider for the
+// CircuitState gauge and the health endpoint
+func SetCircuitState(provider, state string) {
+	circuitsMu.Lock()
+	circuits[provider] = state
+	circuitsMu.Unlock()
+
+	CircuitState.WithLabelValues(provider).Set(circuitStateValues[state])
+}
+
+// healthStatus is the body of the health endpoint
+type healthStatus struct {
+	Status   string            `json:"status"`
+	Circuits map[string]string `json:"circuits,omitempty"`
+}
+
+// healthHandler reports the worker as "ok", or "degraded" while any provider
+// circuit is not closed. It always answers 200 because restarting the worker
+// doesn't help a failing provider.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	status := healthStatus{Status: "ok", Circuits: map[string]string{}}
+
+	circuitsMu.RLock()
+	for provider, state := range circuits {
+		status.Circuits[provider] = state
+		if state != CircuitClosed {
+			status.Status = "degraded"
+		}
+	}
+	circuitsMu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(status)
+}
+
 // StartMetricsServer starts the Prometheus metrics HTTP server
 func StartMetricsServer(port string, metricsPath string) {
 	http.Handle(metricsPath, promhttp.Handler())
 	
 	// Health check endpoint
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	})
+	http.HandleFunc("/health", healthHandler)
 	
 	go func() {
 		if err := http.ListenAndServe(":"+port, nil); err != nil {
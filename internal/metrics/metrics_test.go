@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -62,4 +63,30 @@ func TestDurationHistogram(t *testing.T) {
 	timer2 := prometheus.NewTimer(APIRequestDuration)
 	time.Sleep(1 * time.Millisecond) // Simulate work
 	timer2.ObserveDuration()
-}
\ No newline at end of file
+}
+func TestHealthHandler(t *testing.T) {
+	SetCircuitState("health-test", CircuitOpen)
+
+	w := httptest.NewRecorder()
+	healthHandler(w, httptest.NewRequest("GET", "/health", nil))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", w.Code)
+	}
+
+	var status healthStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("failed to parse health response: %v", err)
+	}
+	if status.Status != "degraded" || status.Circuits["health-test"] != CircuitOpen {
+		t.Errorf("expected degraded status with open circuit, got %+v", status)
+	}
+
+	SetCircuitState("health-test", CircuitClosed)
+	w = httptest.NewRecorder()
+	healthHandler(w, httptest.NewRequest("GET", "/health", nil))
+	json.Unmarshal(w.Body.Bytes(), &status)
+	if status.Status != "ok" {
+		t.Errorf("expected ok status, got %+v", status)
+	}
+}
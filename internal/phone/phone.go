@@ -0,0 +1,180 @@
+package phone
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Number is a phone number normalized to E.164
+type Number struct {
+	// E164 holds the digits of the number including the country code, without "+"
+	E164 string
+	// CountryCode is the calling code, e.g. "7". Empty for countries without
+	// a known numbering plan.
+	CountryCode string
+	// Country is the ISO 3166 code of the country, e.g. "RU". Empty for
+	// countries without a known numbering plan.
+	Country string
+}
+
+// ErrInvalid is returned for input that is not a valid phone number
+type ErrInvalid struct {
+	Input  string
+	Reason string
+}
+
+func (e *ErrInvalid) Error() string {
+	return fmt.Sprintf("invalid phone number %q: %s", e.Input, e.Reason)
+}
+
+// country describes the numbering plan of a calling code
+type country struct {
+	iso  string
+	code string
+	// length is the number of digits after the country code
+	length int
+	// trunk is the prefix dialled before national numbers, if any
+	trunk string
+	// prefixes lists allowed first digits of national numbers, empty allows any
+	prefixes string
+}
+
+// E.164 limits for numbers of countries without a known numbering plan
+const (
+	minDigits = 8
+	maxDigits = 15
+)
+
+// countries lists the known numbering plans, used to handle trunk prefixes
+// and check lengths. Numbers of other countries only get the generic E.164
+// checks. Only countries with a fixed national number length are listed. Russia and Kazakhstan share the calling
+// code 7 and are told apart by the first national digit.
+var countries = []country{
+	{iso: "RU", code: "7", length: 10, trunk: "8", prefixes: "3489"},
+	{iso: "KZ", code: "7", length: 10, trunk: "8", prefixes: "67"},
+	{iso: "BY", code: "375", length: 9, trunk: "80"},
+	{iso: "UA", code: "380", length: 9, trunk: "0"},
+	{iso: "UZ", code: "998", length: 9},
+	{iso: "KG", code: "996", length: 9, trunk: "0"},
+	{iso: "TJ", code: "992", length: 9},
+	{iso: "AM", code: "374", length: 8, trunk: "0"},
+	{iso: "AZ", code: "994", length: 9, trunk: "0"},
+	{iso: "GE", code: "995", length: 9},
+	{iso: "MD", code: "373", length: 8, trunk: "0"},
+	{iso: "US", code: "1", length: 10},
+	{iso: "GB", code: "44", length: 10, trunk: "0"},
+	{iso: "TR", code: "90", length: 10, trunk: "0"},
+	{iso: "CN", code: "86", length: 11},
+}
+
+// Normalize converts a phone number in international or national notation to
+// E.164. Numbers without a "+" or "00" prefix are interpreted in
+// defaultCountry unless they already start with its calling code.
+func Normalize(input, defaultCountry string) (Number, error) {
+	digits, international, err := clean(input)
+	if err != nil {
+		return Number{}, err
+	}
+
+	if international {
+		return classify(input, digits)
+	}
+
+	home, ok := lookupISO(defaultCountry)
+	if !ok {
+		return Number{}, fmt.Errorf("unknown default country %q", defaultCountry)
+	}
+
+	switch {
+	case len(digits) == len(home.code)+home.length && strings.HasPrefix(digits, home.code):
+		// Already international, just without the "+"
+	case home.trunk != "" && len(digits) == len(home.trunk)+home.length && strings.HasPrefix(digits, home.trunk):
+		digits = home.code + digits[len(home.trunk):]
+	case len(digits) == home.length:
+		digits = home.code + digits
+	default:
+		return Number{}, &ErrInvalid{Input: input, Reason: "invalid length"}
+	}
+
+	return classify(input, digits)
+}
+
+// clean strips formatting characters and reports whether the number was
+// written with an international prefix
+func clean(input string) (string, bool, error) {
+	s := strings.TrimSpace(input)
+	international := false
+	switch {
+	case strings.HasPrefix(s, "+"):
+		international = true
+		s = s[1:]
+	case strings.HasPrefix(s, "00"):
+		international = true
+		s = s[2:]
+	}
+
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '(' || r == ')' || r == '.':
+		default:
+			return "", false, &ErrInvalid{Input: input, Reason: fmt.Sprintf("unexpected character %q", r)}
+		}
+	}
+
+	if b.Len() == 0 {
+		return "", false, &ErrInvalid{Input: input, Reason: "no digits"}
+	}
+	return b.String(), international, nil
+}
+
+// classify finds the country of international digits and checks the length
+// and prefix of the national part. Numbers of unknown countries are accepted
+// if they look like E.164.
+func classify(input, digits string) (Number, error) {
+	matched := false
+	for _, c := range countries {
+		if !strings.HasPrefix(digits, c.code) {
+			continue
+		}
+		matched = true
+
+		national := digits[len(c.code):]
+		if len(national) != c.length {
+			continue
+		}
+		if c.prefixes != "" && !strings.ContainsRune(c.prefixes, rune(national[0])) {
+			continue
+		}
+		return Number{E164: digits, CountryCode: c.code, Country: c.iso}, nil
+	}
+
+	if matched {
+		return Number{}, &ErrInvalid{Input: input, Reason: "invalid length or prefix"}
+	}
+	if digits[0] == '0' {
+		return Number{}, &ErrInvalid{Input: input, Reason: "country code can't start with 0"}
+	}
+	if len(digits) < minDigits || len(digits) > maxDigits {
+		return Number{}, &ErrInvalid{Input: input, Reason: "invalid length"}
+	}
+	return Number{E164: digits}, nil
+}
+
+// IsCountry reports whether iso is a country with a known numbering plan,
+// which national numbers can be interpreted in
+func IsCountry(iso string) bool {
+	_, ok := lookupISO(iso)
+	return ok
+}
+
+func lookupISO(iso string) (country, bool) {
+	for _, c := range countries {
+		if strings.EqualFold(c.iso, iso) {
+			return c, true
+		}
+	}
+	return country{}, false
+}
@@ -0,0 +1,96 @@
+package phone
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		input   string
+		e164    string
+		country string
+	}{
+		{"79218897127", "79218897127", "RU"},
+		{"+7 (921) 889-71-27", "79218897127", "RU"},
+		{"89218897127", "79218897127", "RU"},
+		{"9218897127", "79218897127", "RU"},
+		{"8 (495) 123-45-67", "74951234567", "RU"},
+		{"+7 701 123 45 67", "77011234567", "KZ"},
+		{"+375 29 123-45-67", "375291234567", "BY"},
+		{"00380501234567", "380501234567", "UA"},
+		{"+1 (212) 555-0100", "12125550100", "US"},
+		{"+49 30 1234567", "49301234567", ""},
+		{"+358 40 1234567", "358401234567", ""},
+		{"+372 5123 4567", "37251234567", ""},
+		{"00972 50-123-4567", "972501234567", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			n, err := Normalize(test.input, "RU")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if n.E164 != test.e164 {
+				t.Errorf("expected '%s', got '%s'", test.e164, n.E164)
+			}
+			if n.Country != test.country {
+				t.Errorf("expected country '%s', got '%s'", test.country, n.Country)
+			}
+		})
+	}
+}
+
+func TestNormalizeInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"garbage",
+		"+7 921 889",
+		"792188971270",
+		"+7 (121) 889-71-27", // no such prefix
+		"+0123456789",        // country code can't start with 0
+		"+49 123",            // too short for E.164
+		"+49 12345678901234", // too long for E.164
+		"921-889-71-27 ext 5",
+	}
+
+	for _, input := range tests {
+		t.Run(input, func(t *testing.T) {
+			_, err := Normalize(input, "RU")
+			var invalid *ErrInvalid
+			if !errors.As(err, &invalid) {
+				t.Errorf("expected ErrInvalid, got %v", err)
+			}
+		})
+	}
+}
+
+func TestNormalizeDefaultCountry(t *testing.T) {
+	n, err := Normalize("029 123-45-67", "BY")
+	if err == nil {
+		t.Errorf("expected national number with wrong trunk prefix to fail, got %+v", n)
+	}
+
+	n, err = Normalize("80291234567", "BY")
+	if err != nil || n.E164 != "375291234567" {
+		t.Errorf("expected '375291234567', got %+v, %v", n, err)
+	}
+
+	if _, err := Normalize("79218897127", "XX"); err == nil {
+		t.Error("expected error for unknown default country")
+	}
+}
+
+func TestIsCountry(t *testing.T) {
+	for _, iso := range []string{"RU", "by", "US"} {
+		if !IsCountry(iso) {
+			t.Errorf("expected %s to be a known country", iso)
+		}
+	}
+	for _, iso := range []string{"DE", "RUS", ""} {
+		if IsCountry(iso) {
+			t.Errorf("expected %s not to be a known country", iso)
+		}
+	}
+}
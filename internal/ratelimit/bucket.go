@@ -0,0 +1,89 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TokenBucket allows rate events per second with bursts of up to burst events
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// NewTokenBucket creates a full bucket refilled at rate tokens per second
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	b := &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+	}
+	b.last = b.now()
+	return b
+}
+
+// Allow takes a token if one is available. Otherwise it returns false and
+// how long until the next token.
+func (b *TokenBucket) Allow() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, b.waitFor(1 - b.tokens)
+}
+
+// Wait takes a token, blocking until one is available or ctx is done. It
+// reports whether it had to wait.
+func (b *TokenBucket) Wait(ctx context.Context) (bool, error) {
+	b.mu.Lock()
+	b.refill()
+	b.tokens--
+	wait := b.waitFor(-b.tokens)
+	b.mu.Unlock()
+
+	if wait <= 0 {
+		return false, nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true, nil
+	case <-ctx.Done():
+		// Give the reserved token back
+		b.mu.Lock()
+		b.tokens++
+		b.mu.Unlock()
+		return true, ctx.Err()
+	}
+}
+
+// refill adds the tokens accumulated since the last call
+func (b *TokenBucket) refill() {
+	now := b.now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// waitFor returns the time needed to accumulate the given number of tokens
+func (b *TokenBucket) waitFor(tokens float64) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+	return time.Duration(tokens / b.rate * float64(time.Second))
+}
@@ -0,0 +1,132 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/starline/rabbitmq-worker/internal/config"
+	"github.com/starline/rabbitmq-worker/internal/metrics"
+)
+
+// Over-limit policies
+const (
+	// PolicyDelay postpones over-limit messages
+	PolicyDelay = "delay"
+	// PolicyReject drops over-limit messages
+	PolicyReject = "reject"
+)
+
+// Limit scopes
+const (
+	ScopeGlobal    = "global"
+	ScopeRecipient = "recipient"
+)
+
+// ErrLimited is returned when a message exceeds a rate limit
+type ErrLimited struct {
+	Scope string
+	// RetryAfter is when the message may be sent again
+	RetryAfter time.Duration
+	// Rejected is set when the policy drops over-limit messages
+	Rejected bool
+}
+
+func (e *ErrLimited) Error() string {
+	return fmt.Sprintf("%s rate limit exceeded (retry after %s)", e.Scope, e.RetryAfter)
+}
+
+// Limiter applies the global and per-recipient limits in front of the provider
+type Limiter struct {
+	policy     string
+	global     *TokenBucket
+	recipients *RecipientLimiter
+}
+
+// New creates a limiter from the configuration. Limits that are not
+// configured are not applied.
+func New(cfg *config.RateLimitConfig) *Limiter {
+	l := &Limiter{policy: cfg.Policy}
+	if l.policy == "" {
+		l.policy = PolicyDelay
+	}
+	if cfg.GlobalRate > 0 {
+		l.global = NewTokenBucket(cfg.GlobalRate, cfg.GlobalBurst)
+	}
+	if cfg.RecipientMax > 0 && cfg.RecipientWindow > 0 {
+		l.recipients = NewRecipientLimiter(cfg.RecipientMax, cfg.RecipientWindow)
+	}
+	return l
+}
+
+// Reservation is the recipient slot taken by Acquire
+type Reservation struct {
+	recipients *RecipientLimiter
+	key        string
+	at         time.Time
+}
+
+// Cancel gives the recipient slot back when the message was not sent, so
+// failed attempts don't count against the recipient's quota
+func (r *Reservation) Cancel() {
+	if r == nil || r.recipients == nil {
+		return
+	}
+	r.recipients.Release(r.key, r.at)
+	r.recipients = nil
+}
+
+// Acquire checks whether a message may be sent to recipient now. The global
+// limit is checked first so that a message held back by it doesn't use up
+// the recipient's quota. The returned reservation must be cancelled if the
+// message isn't sent after all.
+//
+// With the delay policy an exhausted global limit blocks until a token is
+// available, while an exhausted recipient limit returns an ErrLimited so the
+// message can be retried later. With the reject policy both return an
+// ErrLimited marked as rejected.
+func (l *Limiter) Acquire(ctx context.Context, recipient string) (*Reservation, error) {
+	if err := l.acquireGlobal(ctx); err != nil {
+		return nil, err
+	}
+
+	if l.recipients == nil {
+		return nil, nil
+	}
+	at, ok, retryAfter := l.recipients.reserve(recipient)
+	if !ok {
+		return nil, l.limited(ScopeRecipient, retryAfter)
+	}
+	return &Reservation{recipients: l.recipients, key: recipient, at: at}, nil
+}
+
+// acquireGlobal takes a token of the global limit
+func (l *Limiter) acquireGlobal(ctx context.Context) error {
+	if l.global == nil {
+		return nil
+	}
+
+	if l.policy == PolicyReject {
+		if ok, retryAfter := l.global.Allow(); !ok {
+			return l.limited(ScopeGlobal, retryAfter)
+		}
+		return nil
+	}
+
+	waited, err := l.global.Wait(ctx)
+	if waited {
+		metrics.SendsThrottled.WithLabelValues(ScopeGlobal, "waited").Inc()
+	}
+	return err
+}
+
+func (l *Limiter) limited(scope string, retryAfter time.Duration) error {
+	rejected := l.policy == PolicyReject
+	action := "delayed"
+	if rejected {
+		action = "rejected"
+	}
+	metrics.SendsThrottled.WithLabelValues(scope, action).Inc()
+
+	return &ErrLimited{Scope: scope, RetryAfter: retryAfter, Rejected: rejected}
+}
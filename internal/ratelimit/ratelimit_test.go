@@ -0,0 +1,182 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/starline/rabbitmq-worker/internal/config"
+)
+
+func TestTokenBucketAllow(t *testing.T) {
+	now := time.Now()
+	b := NewTokenBucket(2, 2)
+	b.now = func() time.Time { return now }
+	b.last = now
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := b.Allow(); !ok {
+			t.Fatalf("expected burst token %d to be allowed", i)
+		}
+	}
+
+	ok, wait := b.Allow()
+	if ok {
+		t.Fatal("expected empty bucket to refuse")
+	}
+	if wait != 500*time.Millisecond {
+		t.Errorf("expected 500ms until next token, got %s", wait)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := b.Allow(); !ok {
+		t.Error("expected refilled token to be allowed")
+	}
+}
+
+func TestTokenBucketWait(t *testing.T) {
+	b := NewTokenBucket(100, 1)
+
+	if waited, err := b.Wait(context.Background()); waited || err != nil {
+		t.Fatalf("expected first token without waiting, got %v, %v", waited, err)
+	}
+
+	start := time.Now()
+	if waited, err := b.Wait(context.Background()); !waited || err != nil {
+		t.Fatalf("expected to wait for second token, got %v, %v", waited, err)
+	}
+	if elapsed := time.Since(start); elapsed < 5*time.Millisecond {
+		t.Errorf("expected to wait about 10ms, waited %s", elapsed)
+	}
+
+	slow := NewTokenBucket(0.001, 1)
+	slow.Allow()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := slow.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestRecipientLimiter(t *testing.T) {
+	now := time.Now()
+	l := NewRecipientLimiter(2, 10*time.Minute)
+	l.now = func() time.Time { return now }
+
+	l.Allow("79218897127")
+	now = now.Add(time.Minute)
+	l.Allow("79218897127")
+
+	ok, retryAfter := l.Allow("79218897127")
+	if ok {
+		t.Fatal("expected third message within window to be refused")
+	}
+	if retryAfter != 9*time.Minute {
+		t.Errorf("expected retry after 9m, got %s", retryAfter)
+	}
+	if ok, _ := l.Allow("79210000000"); !ok {
+		t.Error("expected other recipient to be allowed")
+	}
+
+	now = now.Add(9 * time.Minute)
+	if ok, _ := l.Allow("79218897127"); !ok {
+		t.Error("expected message to be allowed once the oldest left the window")
+	}
+}
+
+func TestLimiterPolicies(t *testing.T) {
+	tests := []struct {
+		policy   string
+		rejected bool
+	}{
+		{PolicyDelay, false},
+		{PolicyReject, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			l := New(&config.RateLimitConfig{
+				RecipientMax:    1,
+				RecipientWindow: time.Minute,
+				Policy:          test.policy,
+			})
+
+			if _, err := l.Acquire(context.Background(), "79218897127"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			_, err := l.Acquire(context.Background(), "79218897127")
+			var limited *ErrLimited
+			if !errors.As(err, &limited) {
+				t.Fatalf("expected ErrLimited, got %v", err)
+			}
+			if limited.Scope != ScopeRecipient || limited.Rejected != test.rejected {
+				t.Errorf("unexpected error %+v", limited)
+			}
+		})
+	}
+}
+
+func TestLimiterGlobalReject(t *testing.T) {
+	l := New(&config.RateLimitConfig{GlobalRate: 0.001, GlobalBurst: 1, Policy: PolicyReject})
+
+	if _, err := l.Acquire(context.Background(), "a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err := l.Acquire(context.Background(), "b")
+	var limited *ErrLimited
+	if !errors.As(err, &limited) || limited.Scope != ScopeGlobal {
+		t.Errorf("expected global limit error, got %v", err)
+	}
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	l := New(&config.RateLimitConfig{})
+	for i := 0; i < 100; i++ {
+		if _, err := l.Acquire(context.Background(), "79218897127"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestLimiterCancel(t *testing.T) {
+	l := New(&config.RateLimitConfig{RecipientMax: 1, RecipientWindow: time.Minute})
+
+	res, err := l.Acquire(context.Background(), "79218897127")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res.Cancel()
+	res.Cancel()
+
+	if _, err := l.Acquire(context.Background(), "79218897127"); err != nil {
+		t.Errorf("expected cancelled slot to be given back, got %v", err)
+	}
+	if _, err := l.Acquire(context.Background(), "79218897127"); err == nil {
+		t.Error("expected second send within window to be limited")
+	}
+}
+
+func TestLimiterGlobalBeforeRecipient(t *testing.T) {
+	l := New(&config.RateLimitConfig{
+		GlobalRate:      0.001,
+		GlobalBurst:     1,
+		RecipientMax:    1,
+		RecipientWindow: time.Minute,
+		Policy:          PolicyReject,
+	})
+
+	if _, err := l.Acquire(context.Background(), "a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := l.Acquire(context.Background(), "b"); err == nil {
+		t.Fatal("expected global limit error")
+	}
+
+	// The global rejection didn't use up the quota of b
+	l.global = NewTokenBucket(1000, 1)
+	if _, err := l.Acquire(context.Background(), "b"); err != nil {
+		t.Errorf("expected recipient slot to be free, got %v", err)
+	}
+}
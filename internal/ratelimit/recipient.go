@@ -0,0 +1,90 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// RecipientLimiter allows at most max events per key within a sliding window
+type RecipientLimiter struct {
+	mu        sync.Mutex
+	max       int
+	window    time.Duration
+	events    map[string][]time.Time
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewRecipientLimiter creates a limiter allowing max events per key per window
+func NewRecipientLimiter(max int, window time.Duration) *RecipientLimiter {
+	return &RecipientLimiter{
+		max:    max,
+		window: window,
+		events: make(map[string][]time.Time),
+		now:    time.Now,
+	}
+}
+
+// Allow records an event for key if the limit allows it. Otherwise it
+// returns false and how long until the oldest event leaves the window.
+func (l *RecipientLimiter) Allow(key string) (bool, time.Duration) {
+	_, ok, retryAfter := l.reserve(key)
+	return ok, retryAfter
+}
+
+// reserve is Allow that also returns the time of the recorded event, so it
+// can be released
+func (l *RecipientLimiter) reserve(key string) (time.Time, bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	events := l.recent(l.events[key], now)
+	if len(events) >= l.max {
+		l.events[key] = events
+		return time.Time{}, false, events[0].Add(l.window).Sub(now)
+	}
+
+	l.events[key] = append(events, now)
+	return now, true, 0
+}
+
+// Release forgets an event recorded for key at the given time, giving the
+// slot back
+func (l *RecipientLimiter) Release(key string, at time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	events := l.events[key]
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].Equal(at) {
+			l.events[key] = append(events[:i:i], events[i+1:]...)
+			return
+		}
+	}
+}
+
+// recent drops events that left the window
+func (l *RecipientLimiter) recent(events []time.Time, now time.Time) []time.Time {
+	i := 0
+	for i < len(events) && now.Sub(events[i]) >= l.window {
+		i++
+	}
+	return events[i:]
+}
+
+// sweep forgets keys without recent events, at most once per window
+func (l *RecipientLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.window {
+		return
+	}
+	l.lastSweep = now
+
+	for key, events := range l.events {
+		if len(l.recent(events, now)) == 0 {
+			delete(l.events, key)
+		}
+	}
+}
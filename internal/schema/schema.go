@@ -0,0 +1,151 @@
+package schema
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// DefaultVersion is the schema version of deliveries that don't name one
+const DefaultVersion = "1"
+
+// Reasons a payload is invalid
+const (
+	ReasonJSON    = "json"
+	ReasonSchema  = "schema"
+	ReasonVersion = "version"
+)
+
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+// ErrInvalid is returned for a payload that doesn't match its schema
+type ErrInvalid struct {
+	Version string
+	Reason  string
+	// Errors lists the problems found, each prefixed with the JSON pointer
+	// of the offending value
+	Errors []string
+}
+
+func (e *ErrInvalid) Error() string {
+	return fmt.Sprintf("invalid payload (schema v%s, %s): %s", e.Version, e.Reason, strings.Join(e.Errors, "; "))
+}
+
+// Validator checks message request payloads against the embedded schemas
+type Validator struct {
+	schemas map[string]*jsonschema.Schema
+}
+
+// New compiles the embedded schemas, one per version
+func New() (*Validator, error) {
+	files, err := schemaFiles.ReadDir("schemas")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list schemas: %w", err)
+	}
+
+	v := &Validator{schemas: map[string]*jsonschema.Schema{}}
+	for _, file := range files {
+		// message-request.v1.json holds version 1
+		name := strings.TrimSuffix(file.Name(), ".json")
+		version := name[strings.LastIndex(name, ".v")+2:]
+
+		data, err := schemaFiles.ReadFile(path.Join("schemas", file.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read schema %s: %w", file.Name(), err)
+		}
+
+		compiler := jsonschema.NewCompiler()
+		compiler.Draft = jsonschema.Draft2020
+		compiler.AssertFormat = true
+		if err := compiler.AddResource(file.Name(), bytes.NewReader(data)); err != nil {
+			return nil, fmt.Errorf("failed to load schema %s: %w", file.Name(), err)
+		}
+		s, err := compiler.Compile(file.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile schema %s: %w", file.Name(), err)
+		}
+		v.schemas[version] = s
+	}
+	return v, nil
+}
+
+// MustNew is like New but panics if the embedded schemas are broken
+func MustNew() *Validator {
+	v, err := New()
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// Versions returns the supported schema versions
+func (v *Validator) Versions() []string {
+	versions := make([]string, 0, len(v.schemas))
+	for version := range v.schemas {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// Validate checks body against the given schema version, DefaultVersion if
+// empty. The returned error is an *ErrInvalid.
+func (v *Validator) Validate(version string, body []byte) error {
+	if version == "" {
+		version = DefaultVersion
+	}
+
+	s, ok := v.schemas[version]
+	if !ok {
+		return &ErrInvalid{
+			Version: version,
+			Reason:  ReasonVersion,
+			Errors:  []string{fmt.Sprintf("unsupported schema version, expected one of %s", strings.Join(v.Versions(), ", "))},
+		}
+	}
+
+	// Numbers are kept as json.Number so integer checks stay exact
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return &ErrInvalid{Version: version, Reason: ReasonJSON, Errors: []string{err.Error()}}
+	}
+	if dec.More() {
+		return &ErrInvalid{Version: version, Reason: ReasonJSON, Errors: []string{"unexpected data after JSON value"}}
+	}
+
+	if err := s.Validate(doc); err != nil {
+		var ve *jsonschema.ValidationError
+		if !errors.As(err, &ve) {
+			return &ErrInvalid{Version: version, Reason: ReasonSchema, Errors: []string{err.Error()}}
+		}
+		return &ErrInvalid{Version: version, Reason: ReasonSchema, Errors: leafErrors(ve)}
+	}
+	return nil
+}
+
+// leafErrors flattens a validation error tree into its most specific causes
+func leafErrors(ve *jsonschema.ValidationError) []string {
+	if len(ve.Causes) == 0 {
+		location := ve.InstanceLocation
+		if location == "" {
+			location = "/"
+		}
+		return []string{location + ": " + ve.Message}
+	}
+
+	var errs []string
+	for _, cause := range ve.Causes {
+		errs = append(errs, leafErrors(cause)...)
+	}
+	return errs
+}
@@ -0,0 +1,66 @@
+package schema
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	v, err := New()
+	if err != nil {
+		t.Fatalf("failed to compile schemas: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		version string
+		body    string
+		reason  string
+		errors  []string
+	}{
+		{"minimal", "", `{"messages":[{"recipient":"79218897127","body":"test"}]}`, "", nil},
+		{"template", "1", `{"messages":[{"recipient":"79218897127","template":"otp","params":{"code":"1"}}]}`, "", nil},
+		{"all fields", "1", `{"messages":[{
+			"id":"42","recipient":"79218897127","body":"test","type":"otp","priority":9,
+			"expires_at":"2024-05-01T12:00:00Z","ttl":300,"sender":"StarLine",
+			"callback_url":"https://example.com/dlr","tags":["auth"],"transliterate":"never"
+		}]}`, "", nil},
+		{"not json", "1", `{"messages":`, ReasonJSON, nil},
+		{"trailing data", "1", `{"messages":[{"recipient":"1","body":"x"}]} {}`, ReasonJSON, nil},
+		{"unknown version", "7", `{}`, ReasonVersion, nil},
+		{"empty object", "1", `{}`, ReasonSchema, []string{"/: missing properties: 'messages'"}},
+		{"empty messages", "1", `{"messages":[]}`, ReasonSchema, []string{"/messages: minimum 1 items required, but found 0 items"}},
+		{"unknown field", "1", `{"messages":[{"recipient":"1","body":"x","colour":"red"}]}`, ReasonSchema, []string{"/messages/0: additionalProperties 'colour' not allowed"}},
+		{"empty recipient", "1", `{"messages":[{"recipient":"","body":"x"}]}`, ReasonSchema, []string{"/messages/0/recipient: length must be >= 1, but got 0"}},
+		{"no body", "1", `{"messages":[{"recipient":"1"}]}`, ReasonSchema, nil},
+		{"bad priority", "1", `{"messages":[{"recipient":"1","body":"x","priority":1.5}]}`, ReasonSchema, nil},
+		{"bad expires_at", "1", `{"messages":[{"recipient":"1","body":"x","expires_at":"tomorrow"}]}`, ReasonSchema, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := v.Validate(test.version, []byte(test.body))
+			if test.reason == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			var invalid *ErrInvalid
+			if !errors.As(err, &invalid) {
+				t.Fatalf("expected ErrInvalid, got %v", err)
+			}
+			if invalid.Reason != test.reason {
+				t.Errorf("expected reason %s, got %s (%v)", test.reason, invalid.Reason, invalid.Errors)
+			}
+			if len(invalid.Errors) == 0 {
+				t.Error("expected validation errors")
+			}
+			if test.errors != nil && strings.Join(invalid.Errors, "\n") != strings.Join(test.errors, "\n") {
+				t.Errorf("expected errors %q, got %q", test.errors, invalid.Errors)
+			}
+		})
+	}
+}
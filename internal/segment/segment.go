@@ -0,0 +1,100 @@
+package segment
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// Encodings of an SMS body
+const (
+	GSM7 = "gsm7"
+	UCS2 = "ucs2"
+)
+
+// Per-segment capacities. A multipart message loses room to the
+// concatenation header in every segment.
+const (
+	gsm7Single    = 160
+	gsm7Multipart = 153
+	ucs2Single    = 70
+	ucs2Multipart = 67
+)
+
+// gsm7Basic is the GSM 03.38 basic character set
+const gsm7Basic = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+
+// gsm7Extension characters are sent as an escape plus a character, taking two septets
+const gsm7Extension = "\f^{}\\[~]|€"
+
+// Info describes how a body is encoded and billed
+type Info struct {
+	Encoding string
+	// Units is the length in septets for GSM-7 or UTF-16 code units for UCS-2
+	Units int
+	// Segments is the number of SMS parts the body is split into
+	Segments int
+}
+
+// ErrTooManySegments is returned for a body longer than the allowed number of segments
+type ErrTooManySegments struct {
+	Segments int
+	Max      int
+}
+
+func (e *ErrTooManySegments) Error() string {
+	return fmt.Sprintf("message needs %d segments, at most %d allowed", e.Segments, e.Max)
+}
+
+// Analyze detects the encoding of body and counts its segments
+func Analyze(body string) Info {
+	if septets, ok := gsm7Length(body); ok {
+		return Info{Encoding: GSM7, Units: septets, Segments: count(septets, gsm7Single, gsm7Multipart)}
+	}
+
+	units := len(utf16.Encode([]rune(body)))
+	return Info{Encoding: UCS2, Units: units, Segments: count(units, ucs2Single, ucs2Multipart)}
+}
+
+// Check returns an ErrTooManySegments if body needs more than max segments.
+// A max of zero allows any length.
+func Check(body string, max int) (Info, error) {
+	info := Analyze(body)
+	if max > 0 && info.Segments > max {
+		return info, &ErrTooManySegments{Segments: info.Segments, Max: max}
+	}
+	return info, nil
+}
+
+// IsGSM7 reports whether body can be sent in the GSM-7 alphabet
+func IsGSM7(body string) bool {
+	_, ok := gsm7Length(body)
+	return ok
+}
+
+// gsm7Length returns the length of body in septets, or false if it contains
+// characters outside the GSM-7 alphabet
+func gsm7Length(body string) (int, bool) {
+	septets := 0
+	for _, r := range body {
+		switch {
+		case strings.ContainsRune(gsm7Basic, r):
+			septets++
+		case strings.ContainsRune(gsm7Extension, r):
+			septets += 2
+		default:
+			return 0, false
+		}
+	}
+	return septets, true
+}
+
+// count returns the number of segments for a body of the given length.
+// An empty body still takes one segment.
+func count(length, single, multipart int) int {
+	if length <= single {
+		return 1
+	}
+	return (length + multipart - 1) / multipart
+}
@@ -0,0 +1,60 @@
+package segment
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		encoding string
+		units    int
+		segments int
+	}{
+		{"empty", "", GSM7, 0, 1},
+		{"latin", "StarLine code: 2652", GSM7, 19, 1},
+		{"extension chars", "{code}", GSM7, 8, 1},
+		{"gsm7 single limit", strings.Repeat("a", 160), GSM7, 160, 1},
+		{"gsm7 multipart", strings.Repeat("a", 161), GSM7, 161, 2},
+		{"gsm7 three parts", strings.Repeat("a", 307), GSM7, 307, 3},
+		{"cyrillic", "StarLine код авторизации: 2652", UCS2, 30, 1},
+		{"ucs2 single limit", strings.Repeat("ж", 70), UCS2, 70, 1},
+		{"ucs2 multipart", strings.Repeat("ж", 71), UCS2, 71, 2},
+		{"surrogate pair", "😀", UCS2, 2, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info := Analyze(test.body)
+			if info.Encoding != test.encoding {
+				t.Errorf("expected encoding %s, got %s", test.encoding, info.Encoding)
+			}
+			if info.Units != test.units {
+				t.Errorf("expected %d units, got %d", test.units, info.Units)
+			}
+			if info.Segments != test.segments {
+				t.Errorf("expected %d segments, got %d", test.segments, info.Segments)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	body := strings.Repeat("ж", 140)
+
+	if _, err := Check(body, 0); err != nil {
+		t.Errorf("expected no limit with max 0, got %v", err)
+	}
+	if _, err := Check(body, 3); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	_, err := Check(body, 1)
+	var tooMany *ErrTooManySegments
+	if !errors.As(err, &tooMany) || tooMany.Segments != 3 {
+		t.Errorf("expected ErrTooManySegments with 3 segments, got %v", err)
+	}
+}
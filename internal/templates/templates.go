@@ -0,0 +1,143 @@
+package templates
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/starline/rabbitmq-worker/internal/config"
+	"github.com/starline/rabbitmq-worker/internal/logging"
+)
+
+// fileExt is the extension of template files in the templates directory
+const fileExt = ".tmpl"
+
+// ErrTemplate is returned when a message template cannot be rendered
+type ErrTemplate struct {
+	Name   string
+	Locale string
+	Err    error
+}
+
+func (e *ErrTemplate) Error() string {
+	return fmt.Sprintf("template %q (%s): %v", e.Name, e.Locale, e.Err)
+}
+
+func (e *ErrTemplate) Unwrap() error {
+	return e.Err
+}
+
+// Store holds message templates by name and locale. Templates come from the
+// configuration and from a directory of "<name>.<locale>.tmpl" files, and can
+// be reloaded while messages are being rendered.
+type Store struct {
+	config *config.TemplatesConfig
+
+	mu        sync.RWMutex
+	templates map[string]map[string]*template.Template
+}
+
+// New creates an empty store, call Reload to load the templates
+func New(cfg *config.TemplatesConfig) *Store {
+	return &Store{
+		config:    cfg,
+		templates: map[string]map[string]*template.Template{},
+	}
+}
+
+// Reload parses all templates again. On error the previously loaded
+// templates are kept.
+func (s *Store) Reload() error {
+	loaded := map[string]map[string]*template.Template{}
+	add := func(name, locale, text string) error {
+		t, err := template.New(name).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return &ErrTemplate{Name: name, Locale: locale, Err: err}
+		}
+		if loaded[name] == nil {
+			loaded[name] = map[string]*template.Template{}
+		}
+		loaded[name][locale] = t
+		return nil
+	}
+
+	for name, locales := range s.config.Items {
+		for locale, text := range locales {
+			if err := add(name, locale, text); err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.config.Dir != "" {
+		paths, err := filepath.Glob(filepath.Join(s.config.Dir, "*"+fileExt))
+		if err != nil {
+			return fmt.Errorf("failed to list templates: %w", err)
+		}
+		for _, path := range paths {
+			text, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to read template: %w", err)
+			}
+			name, locale := s.parseFileName(filepath.Base(path))
+			if err := add(name, locale, string(text)); err != nil {
+				return err
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.templates = loaded
+	s.mu.Unlock()
+
+	logging.Info("message templates loaded", logrus.Fields{
+		"templates": len(loaded),
+		"dir":       s.config.Dir,
+	})
+	return nil
+}
+
+// parseFileName splits "otp.en.tmpl" into the template name and locale. A
+// file without a locale holds the template for the default locale.
+func (s *Store) parseFileName(file string) (string, string) {
+	base := strings.TrimSuffix(file, fileExt)
+	if i := strings.LastIndex(base, "."); i > 0 {
+		return base[:i], base[i+1:]
+	}
+	return base, s.config.DefaultLocale
+}
+
+// Render executes the named template in the given locale, falling back to
+// the default locale when the template has no variant for it. Every
+// parameter used by the template must be present in params.
+func (s *Store) Render(name, locale string, params map[string]any) (string, error) {
+	if locale == "" {
+		locale = s.config.DefaultLocale
+	}
+
+	s.mu.RLock()
+	locales := s.templates[name]
+	t, ok := locales[locale]
+	if !ok {
+		t, ok = locales[s.config.DefaultLocale]
+	}
+	s.mu.RUnlock()
+
+	if !ok {
+		return "", &ErrTemplate{Name: name, Locale: locale, Err: fmt.Errorf("template not found")}
+	}
+
+	if params == nil {
+		params = map[string]any{}
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, params); err != nil {
+		return "", &ErrTemplate{Name: name, Locale: locale, Err: err}
+	}
+	return b.String(), nil
+}
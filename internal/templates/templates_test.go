@@ -0,0 +1,104 @@
+package templates
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/starline/rabbitmq-worker/internal/config"
+)
+
+func TestRender(t *testing.T) {
+	store := New(&config.TemplatesConfig{
+		DefaultLocale: "ru",
+		Items: map[string]map[string]string{
+			"otp": {
+				"ru": "StarLine код авторизации: {{.code}}",
+				"en": "StarLine authorization code: {{.code}}",
+			},
+		},
+	})
+	if err := store.Reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		locale   string
+		expected string
+	}{
+		{"ru", "StarLine код авторизации: 2652"},
+		{"en", "StarLine authorization code: 2652"},
+		{"kk", "StarLine код авторизации: 2652"},
+		{"", "StarLine код авторизации: 2652"},
+	}
+
+	for _, test := range tests {
+		body, err := store.Render("otp", test.locale, map[string]any{"code": "2652"})
+		if err != nil {
+			t.Errorf("locale %q: unexpected error: %v", test.locale, err)
+		}
+		if body != test.expected {
+			t.Errorf("locale %q: expected %q, got %q", test.locale, test.expected, body)
+		}
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	store := New(&config.TemplatesConfig{
+		DefaultLocale: "ru",
+		Items:         map[string]map[string]string{"otp": {"ru": "Код: {{.code}}"}},
+	})
+	if err := store.Reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var tmplErr *ErrTemplate
+	if _, err := store.Render("otp", "ru", nil); !errors.As(err, &tmplErr) {
+		t.Errorf("expected ErrTemplate for missing param, got %v", err)
+	}
+	if _, err := store.Render("unknown", "ru", nil); !errors.As(err, &tmplErr) {
+		t.Errorf("expected ErrTemplate for unknown template, got %v", err)
+	}
+}
+
+func TestReloadDir(t *testing.T) {
+	dir := t.TempDir()
+	write := func(file, text string) {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("alarm.tmpl", "Тревога: {{.car}}")
+	write("alarm.en.tmpl", "Alarm: {{.car}}")
+
+	store := New(&config.TemplatesConfig{Dir: dir, DefaultLocale: "ru"})
+	if err := store.Reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	params := map[string]any{"car": "A123BC"}
+	if body, _ := store.Render("alarm", "en", params); body != "Alarm: A123BC" {
+		t.Errorf("unexpected en body %q", body)
+	}
+	if body, _ := store.Render("alarm", "ru", params); body != "Тревога: A123BC" {
+		t.Errorf("unexpected ru body %q", body)
+	}
+
+	write("alarm.en.tmpl", "ALARM: {{.car}}")
+	if err := store.Reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if body, _ := store.Render("alarm", "en", params); body != "ALARM: A123BC" {
+		t.Errorf("expected reloaded template, got %q", body)
+	}
+
+	// A broken template keeps the previous ones in place
+	write("alarm.en.tmpl", "Alarm: {{.car")
+	if err := store.Reload(); err == nil {
+		t.Error("expected error for broken template")
+	}
+	if body, _ := store.Render("alarm", "en", params); body != "ALARM: A123BC" {
+		t.Errorf("expected previous template to be kept, got %q", body)
+	}
+}
@@ -0,0 +1,88 @@
+package translit
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/starline/rabbitmq-worker/internal/segment"
+)
+
+// Transliteration policies
+const (
+	// PolicyNever sends messages as written
+	PolicyNever = "never"
+	// PolicyAlways transliterates every message
+	PolicyAlways = "always"
+	// PolicyIfSavesSegments transliterates a message only when that makes it
+	// take fewer SMS segments
+	PolicyIfSavesSegments = "if_saves_segments"
+)
+
+// russian is the transliteration table of the Russian passport standard,
+// which follows ICAO Doc 9303
+var russian = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "i", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ъ': "ie", 'ы': "y", 'ь': "", 'э': "e", 'ю': "iu", 'я': "ia",
+}
+
+// IsPolicy reports whether policy is a known transliteration policy
+func IsPolicy(policy string) bool {
+	switch policy {
+	case PolicyNever, PolicyAlways, PolicyIfSavesSegments:
+		return true
+	}
+	return false
+}
+
+// Transliterate replaces Russian letters in s with Latin ones. Everything
+// else, digits included, is left as is.
+func Transliterate(s string) string {
+	runes := []rune(s)
+
+	var b strings.Builder
+	b.Grow(len(s))
+	for i, r := range runes {
+		latin, ok := russian[unicode.ToLower(r)]
+		if !ok {
+			b.WriteRune(r)
+			continue
+		}
+		if unicode.IsUpper(r) {
+			latin = upper(latin, runes, i)
+		}
+		b.WriteString(latin)
+	}
+	return b.String()
+}
+
+// upper capitalizes the transliteration of the i-th rune. A letter within an
+// all-caps word is written in capitals entirely, so "ЩИ" becomes "SHCHI" but
+// "Щи" becomes "Shchi".
+func upper(latin string, runes []rune, i int) string {
+	if latin == "" {
+		return latin
+	}
+	if (i+1 < len(runes) && unicode.IsUpper(runes[i+1])) || (i > 0 && unicode.IsUpper(runes[i-1])) {
+		return strings.ToUpper(latin)
+	}
+	return strings.ToUpper(latin[:1]) + latin[1:]
+}
+
+// Apply returns body changed according to policy and whether it was
+// transliterated. An unknown policy is treated as PolicyNever.
+func Apply(body, policy string) (string, bool) {
+	switch policy {
+	case PolicyAlways:
+		latin := Transliterate(body)
+		return latin, latin != body
+	case PolicyIfSavesSegments:
+		latin := Transliterate(body)
+		if segment.Analyze(latin).Segments < segment.Analyze(body).Segments {
+			return latin, true
+		}
+	}
+	return body, false
+}
@@ -0,0 +1,58 @@
+package translit
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTransliterate(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"StarLine код авторизации: 2652", "StarLine kod avtorizatsii: 2652"},
+		{"Щука и ёж", "Shchuka i ezh"},
+		{"ВНИМАНИЕ", "VNIMANIE"},
+		{"ЩИ", "SHCHI"},
+		{"Объявление", "Obieiavlenie"},
+		{"Юля, 12:30", "Iulia, 12:30"},
+		{"code 0042", "code 0042"},
+	}
+
+	for _, test := range tests {
+		if got := Transliterate(test.input); got != test.expected {
+			t.Errorf("Transliterate(%q) = %q, expected %q", test.input, got, test.expected)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	short := "Код: 2652"
+	long := strings.Repeat("Ваша машина поставлена на охрану. ", 3)
+
+	tests := []struct {
+		name           string
+		body           string
+		policy         string
+		transliterated bool
+	}{
+		{"never", long, PolicyNever, false},
+		{"unknown policy", long, "sometimes", false},
+		{"always", short, PolicyAlways, true},
+		{"saves segments", long, PolicyIfSavesSegments, true},
+		{"same segments", short, PolicyIfSavesSegments, false},
+		{"already latin", "Code: 2652", PolicyAlways, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, transliterated := Apply(test.body, test.policy)
+			if transliterated != test.transliterated {
+				t.Errorf("expected transliterated %v, got %v", test.transliterated, transliterated)
+			}
+			if !transliterated && body != test.body {
+				t.Errorf("expected body to be unchanged, got %q", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/starline/rabbitmq-worker/internal/schema"
+)
+
+// Content types with a built-in codec
+const (
+	ContentTypeJSON        = "application/json"
+	ContentTypeProtobuf    = "application/x-protobuf"
+	ContentTypeCloudEvents = "application/cloudevents+json"
+)
+
+// Invalid payload reasons of the non-JSON codecs
+const (
+	reasonProtobuf    = "protobuf"
+	reasonCloudEvents = "cloudevents"
+)
+
+// Codec decodes a delivery body into a message request. A payload that can't
+// be decoded should be reported as a *schema.ErrInvalid.
+type Codec interface {
+	Decode(d amqp.Delivery) (MessageRequest, error)
+}
+
+// ErrUnsupportedContentType is returned for a delivery no codec can decode
+type ErrUnsupportedContentType struct {
+	ContentType string
+}
+
+func (e *ErrUnsupportedContentType) Error() string {
+	return fmt.Sprintf("unsupported content type %q", e.ContentType)
+}
+
+// WithCodec decodes deliveries of the given content type with codec,
+// replacing the built-in codec if there is one
+func WithCodec(contentType string, codec Codec) Option {
+	return func(w *Worker) {
+		w.codecs[contentType] = codec
+	}
+}
+
+// defaultCodecs returns the built-in codecs. Payloads are validated against
+// the JSON schema after decoding wherever the format allows.
+func defaultCodecs(validator *schema.Validator) map[string]Codec {
+	jsonCodec := &JSONCodec{validator: validator}
+	return map[string]Codec{
+		ContentTypeJSON:        jsonCodec,
+		ContentTypeProtobuf:    ProtobufCodec{},
+		ContentTypeCloudEvents: &CloudEventsCodec{json: jsonCodec},
+	}
+}
+
+// decode picks the codec for the delivery's content type. Deliveries without
+// a content type are JSON, as they were before codecs existed.
+func (w *Worker) decode(d amqp.Delivery) (MessageRequest, error) {
+	contentType := ContentTypeJSON
+	if d.ContentType != "" {
+		mediaType, _, err := mime.ParseMediaType(d.ContentType)
+		if err != nil {
+			return MessageRequest{}, &ErrUnsupportedContentType{ContentType: d.ContentType}
+		}
+		contentType = mediaType
+	}
+
+	codec, ok := w.codecs[contentType]
+	if !ok {
+		return MessageRequest{}, &ErrUnsupportedContentType{ContentType: d.ContentType}
+	}
+	return codec.Decode(d)
+}
+
+// JSONCodec decodes JSON payloads after validating them against the schema
+// version named by the delivery
+type JSONCodec struct {
+	validator *schema.Validator
+}
+
+// Decode validates and decodes a JSON message request
+func (c *JSONCodec) Decode(d amqp.Delivery) (MessageRequest, error) {
+	return c.decode(schemaVersion(d), d.Body)
+}
+
+func (c *JSONCodec) decode(version string, body []byte) (MessageRequest, error) {
+	var req MessageRequest
+	if err := c.validator.Validate(version, body); err != nil {
+		return req, err
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return req, fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+	return req, nil
+}
+
+// cloudEvent is a CloudEvents 1.0 event in structured JSON mode
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// CloudEventsCodec decodes message requests carried as the JSON data of a
+// structured CloudEvents envelope
+type CloudEventsCodec struct {
+	json *JSONCodec
+}
+
+// Decode checks the envelope and decodes its data as a JSON message request
+func (c *CloudEventsCodec) Decode(d amqp.Delivery) (MessageRequest, error) {
+	invalid := func(format string, args ...any) error {
+		return &schema.ErrInvalid{
+			Version: schemaVersion(d),
+			Reason:  reasonCloudEvents,
+			Errors:  []string{fmt.Sprintf(format, args...)},
+		}
+	}
+
+	var event cloudEvent
+	if err := json.Unmarshal(d.Body, &event); err != nil {
+		return MessageRequest{}, invalid("%v", err)
+	}
+
+	switch {
+	case event.SpecVersion != "1.0":
+		return MessageRequest{}, invalid("unsupported specversion %q", event.SpecVersion)
+	case event.ID == "" || event.Source == "" || event.Type == "":
+		return MessageRequest{}, invalid("id, source and type are required")
+	case event.DataContentType != "" && event.DataContentType != ContentTypeJSON:
+		return MessageRequest{}, invalid("unsupported datacontenttype %q", event.DataContentType)
+	case len(bytes.TrimSpace(event.Data)) == 0:
+		return MessageRequest{}, invalid("data is required")
+	}
+
+	return c.json.decode(schemaVersion(d), event.Data)
+}
@@ -0,0 +1,177 @@
+package worker
+
+import (
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/starline/rabbitmq-worker/internal/schema"
+)
+
+// ProtobufCodec decodes message requests encoded as starline.sms.v1.MessageRequest,
+// see internal/schema/schemas/message-request.v1.proto. Unknown fields are
+// rejected like unknown JSON properties are.
+type ProtobufCodec struct{}
+
+// Decode decodes and checks a protobuf message request
+func (ProtobufCodec) Decode(d amqp.Delivery) (MessageRequest, error) {
+	invalid := func(errs ...string) error {
+		return &schema.ErrInvalid{Version: schema.DefaultVersion, Reason: reasonProtobuf, Errors: errs}
+	}
+
+	var req MessageRequest
+	err := walkFields(d.Body, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return unknownField("MessageRequest", num)
+		}
+		msg, err := decodeProtoMessage(value)
+		if err != nil {
+			return fmt.Errorf("messages[%d]: %w", len(req.Messages), err)
+		}
+		req.Messages = append(req.Messages, msg)
+		return nil
+	})
+	if err != nil {
+		return req, invalid(err.Error())
+	}
+
+	// The checks the JSON schema makes on required values
+	var errs []string
+	if len(req.Messages) == 0 {
+		errs = append(errs, "messages: at least one message required")
+	}
+	for i, msg := range req.Messages {
+		if msg.Recipient == "" {
+			errs = append(errs, fmt.Sprintf("messages[%d].recipient: required", i))
+		}
+		if msg.Body == "" && msg.Template == "" {
+			errs = append(errs, fmt.Sprintf("messages[%d]: body or template required", i))
+		}
+	}
+	if len(errs) > 0 {
+		return req, invalid(errs...)
+	}
+	return req, nil
+}
+
+// decodeProtoMessage decodes a starline.sms.v1.Message
+func decodeProtoMessage(b []byte) (Message, error) {
+	var msg Message
+	err := walkFields(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		stringFields := map[protowire.Number]*string{
+			1: &msg.Recipient, 2: &msg.Body, 3: &msg.ID, 4: &msg.Template, 6: &msg.Locale,
+			7: &msg.Transliterate, 8: &msg.Type, 12: &msg.Source, 13: &msg.Sender, 14: &msg.CallbackURL,
+		}
+		if field, ok := stringFields[num]; ok && typ == protowire.BytesType {
+			*field = string(value)
+			return nil
+		}
+
+		switch {
+		case num == 5 && typ == protowire.BytesType:
+			key, val, err := decodeMapEntry(value)
+			if err != nil {
+				return fmt.Errorf("params: %w", err)
+			}
+			if msg.Params == nil {
+				msg.Params = map[string]any{}
+			}
+			msg.Params[key] = val
+		case num == 9 && typ == protowire.VarintType:
+			msg.Priority = int(int32(varint(value)))
+		case num == 10 && typ == protowire.BytesType:
+			t, err := decodeTimestamp(value)
+			if err != nil {
+				return fmt.Errorf("expires_at: %w", err)
+			}
+			msg.ExpiresAt = &t
+		case num == 11 && typ == protowire.VarintType:
+			msg.TTL = int(int32(varint(value)))
+		case num == 15 && typ == protowire.BytesType:
+			msg.Tags = append(msg.Tags, string(value))
+		default:
+			return unknownField("Message", num)
+		}
+		return nil
+	})
+	return msg, err
+}
+
+// decodeMapEntry decodes an entry of a map<string, string> field
+func decodeMapEntry(b []byte) (string, string, error) {
+	var key, value string
+	err := walkFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			key = string(v)
+		case num == 2 && typ == protowire.BytesType:
+			value = string(v)
+		default:
+			return unknownField("map entry", num)
+		}
+		return nil
+	})
+	return key, value, err
+}
+
+// decodeTimestamp decodes a google.protobuf.Timestamp
+func decodeTimestamp(b []byte) (time.Time, error) {
+	var seconds, nanos int64
+	err := walkFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			seconds = int64(varint(v))
+		case num == 2 && typ == protowire.VarintType:
+			nanos = int64(int32(varint(v)))
+		default:
+			return unknownField("Timestamp", num)
+		}
+		return nil
+	})
+	return time.Unix(seconds, nanos).UTC(), err
+}
+
+// walkFields calls fn for every field of an encoded message. Varint values
+// are passed still encoded, to be read with varint.
+func walkFields(b []byte, fn func(num protowire.Number, typ protowire.Type, value []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var value []byte
+		switch typ {
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			value, b = v, b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			value, b = b[:n], b[n:]
+		}
+
+		if err := fn(num, typ, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// varint reads a varint value passed by walkFields
+func varint(b []byte) uint64 {
+	v, _ := protowire.ConsumeVarint(b)
+	return v
+}
+
+func unknownField(message string, num protowire.Number) error {
+	return fmt.Errorf("%s: unexpected field %d", message, num)
+}
@@ -0,0 +1,176 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/starline/rabbitmq-worker/internal/schema"
+)
+
+// protoMessage encodes a starline.sms.v1.Message with a few fields set
+func protoMessage(recipient, body string, priority int, expiresAt time.Time) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, recipient)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, body)
+
+	var param []byte
+	param = protowire.AppendTag(param, 1, protowire.BytesType)
+	param = protowire.AppendString(param, "code")
+	param = protowire.AppendTag(param, 2, protowire.BytesType)
+	param = protowire.AppendString(param, "2652")
+	b = protowire.AppendTag(b, 5, protowire.BytesType)
+	b = protowire.AppendBytes(b, param)
+
+	b = protowire.AppendTag(b, 9, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(priority))
+
+	var ts []byte
+	ts = protowire.AppendTag(ts, 1, protowire.VarintType)
+	ts = protowire.AppendVarint(ts, uint64(expiresAt.Unix()))
+	b = protowire.AppendTag(b, 10, protowire.BytesType)
+	b = protowire.AppendBytes(b, ts)
+
+	b = protowire.AppendTag(b, 15, protowire.BytesType)
+	b = protowire.AppendString(b, "auth")
+	return b
+}
+
+// protoRequest encodes a starline.sms.v1.MessageRequest
+func protoRequest(messages ...[]byte) []byte {
+	var b []byte
+	for _, msg := range messages {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, msg)
+	}
+	return b
+}
+
+func TestProtobufCodec(t *testing.T) {
+	expiresAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	body := protoRequest(protoMessage("79218897127", "Код: 2652", 5, expiresAt))
+
+	req, err := ProtobufCodec{}.Decode(amqp.Delivery{Body: body})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(req.Messages) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(req.Messages))
+	}
+
+	msg := req.Messages[0]
+	if msg.Recipient != "79218897127" || msg.Body != "Код: 2652" || msg.Priority != 5 {
+		t.Errorf("unexpected message %+v", msg)
+	}
+	if msg.Params["code"] != "2652" || len(msg.Tags) != 1 || msg.Tags[0] != "auth" {
+		t.Errorf("unexpected params %v or tags %v", msg.Params, msg.Tags)
+	}
+	if msg.ExpiresAt == nil || !msg.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("unexpected expires_at %v", msg.ExpiresAt)
+	}
+}
+
+func TestProtobufCodecInvalid(t *testing.T) {
+	unknown := protowire.AppendTag(nil, 99, protowire.VarintType)
+	unknown = protowire.AppendVarint(unknown, 1)
+
+	tests := []struct {
+		name string
+		body []byte
+	}{
+		{"empty request", nil},
+		{"truncated", []byte{0x0a, 0x10, 0x01}},
+		{"unknown field", protoRequest(unknown)},
+		{"no recipient", protoRequest(protoMessage("", "test", 0, time.Now()))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ProtobufCodec{}.Decode(amqp.Delivery{Body: test.body})
+			var invalid *schema.ErrInvalid
+			if !errors.As(err, &invalid) || invalid.Reason != reasonProtobuf {
+				t.Errorf("expected protobuf ErrInvalid, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCloudEventsCodec(t *testing.T) {
+	codec := defaultCodecs(schema.MustNew())[ContentTypeCloudEvents]
+
+	req, err := codec.Decode(amqp.Delivery{Body: []byte(`{
+		"specversion": "1.0", "id": "42", "source": "/auth", "type": "ru.starline.sms.send",
+		"datacontenttype": "application/json",
+		"data": {"messages": [{"recipient": "79218897127", "body": "test"}]}
+	}`)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(req.Messages) != 1 || req.Messages[0].Recipient != "79218897127" {
+		t.Errorf("unexpected request %+v", req)
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		reason string
+	}{
+		{"not json", `nope`, reasonCloudEvents},
+		{"old spec", `{"specversion":"0.3","id":"1","source":"/a","type":"t","data":{}}`, reasonCloudEvents},
+		{"no id", `{"specversion":"1.0","source":"/a","type":"t","data":{}}`, reasonCloudEvents},
+		{"xml data", `{"specversion":"1.0","id":"1","source":"/a","type":"t","datacontenttype":"text/xml","data":"<a/>"}`, reasonCloudEvents},
+		{"no data", `{"specversion":"1.0","id":"1","source":"/a","type":"t"}`, reasonCloudEvents},
+		{"invalid data", `{"specversion":"1.0","id":"1","source":"/a","type":"t","data":{"messages":[]}}`, schema.ReasonSchema},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := codec.Decode(amqp.Delivery{Body: []byte(test.body)})
+			var invalid *schema.ErrInvalid
+			if !errors.As(err, &invalid) || invalid.Reason != test.reason {
+				t.Errorf("expected ErrInvalid with reason %s, got %v", test.reason, err)
+			}
+		})
+	}
+}
+
+func TestHandleDeliveryContentTypes(t *testing.T) {
+	var sent int
+	w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		sent++
+		w.WriteHeader(http.StatusOK)
+	})
+
+	deliveries := []amqp.Delivery{
+		{ContentType: "", Body: []byte(`{"messages":[{"recipient":"79218897127","body":"one"}]}`)},
+		{ContentType: "application/json; charset=utf-8", Body: []byte(`{"messages":[{"recipient":"79218897127","body":"two"}]}`)},
+		{ContentType: ContentTypeProtobuf, Body: protoRequest(protoMessage("79218897127", "three", 0, time.Now().Add(time.Hour)))},
+		{ContentType: ContentTypeCloudEvents, Body: []byte(`{"specversion":"1.0","id":"1","source":"/a","type":"t",
+			"data":{"messages":[{"recipient":"79218897127","body":"four"}]}}`)},
+		{ContentType: "application/xml", Body: []byte(`<messages/>`)},
+	}
+	for _, d := range deliveries {
+		d.Acknowledger = &fakeAcknowledger{}
+		w.handleDelivery(context.Background(), d)
+	}
+
+	if sent != 4 {
+		t.Errorf("expected 4 messages to be sent, got %d", sent)
+	}
+
+	pub := w.publisher.(*fakePublisher)
+	if len(pub.published) != 1 {
+		t.Fatalf("expected unsupported content type to be dead-lettered, got %+v", pub.published)
+	}
+	p := pub.published[0]
+	if p.key != "sms.dead" || p.msg.Headers[deadReasonHeader] != reasonContentType {
+		t.Errorf("expected dead-letter with reason '%s', got %s %v", reasonContentType, p.key, p.msg.Headers)
+	}
+}
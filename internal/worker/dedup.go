@@ -0,0 +1,136 @@
+package worker
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/starline/rabbitmq-worker/internal/config"
+)
+
+// DedupStore remembers messages that were already sent so redeliveries
+// don't reach the recipient twice
+type DedupStore interface {
+	// Seen reports whether key was marked completed within the window
+	Seen(key string) (bool, error)
+	// MarkCompleted records that the message with key was sent
+	MarkCompleted(key string) error
+	// Close releases resources held by the store
+	Close() error
+}
+
+// OpenDedupStore creates the store selected by the configuration
+func OpenDedupStore(cfg *config.DedupConfig) (DedupStore, error) {
+	switch cfg.Backend {
+	case "memory":
+		return NewMemoryDedupStore(cfg.Window, cfg.MaxEntries), nil
+	case "bolt":
+		return OpenBoltDedupStore(cfg.Path, cfg.Window)
+	case "none":
+		return noopDedupStore{}, nil
+	default:
+		return nil, fmt.Errorf("unknown dedup backend %q", cfg.Backend)
+	}
+}
+
+// dedupKey identifies the i-th message of a delivery. The AMQP MessageId is
+// used when set, otherwise a hash of recipient, body and timestamp.
+func dedupKey(d amqp.Delivery, i int, msg Message) string {
+	if msg.ID != "" {
+		return "id:" + msg.ID
+	}
+	if d.MessageId != "" {
+		return d.MessageId + "#" + strconv.Itoa(i)
+	}
+
+	h := sha256.New()
+	h.Write([]byte(msg.Recipient))
+	h.Write([]byte{0})
+	h.Write([]byte(msg.Body))
+	h.Write([]byte{0})
+	h.Write([]byte(strconv.FormatInt(d.Timestamp.UnixNano(), 10)))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// noopDedupStore is used when deduplication is disabled
+type noopDedupStore struct{}
+
+func (noopDedupStore) Seen(string) (bool, error)  { return false, nil }
+func (noopDedupStore) MarkCompleted(string) error { return nil }
+func (noopDedupStore) Close() error               { return nil }
+
+// MemoryDedupStore keeps completed keys in memory, evicting the least
+// recently completed ones when full
+type MemoryDedupStore struct {
+	mu         sync.Mutex
+	window     time.Duration
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List
+	now        func() time.Time
+}
+
+type memoryDedupEntry struct {
+	key         string
+	completedAt time.Time
+}
+
+// NewMemoryDedupStore creates an in-memory store remembering up to maxEntries
+// keys for window
+func NewMemoryDedupStore(window time.Duration, maxEntries int) *MemoryDedupStore {
+	return &MemoryDedupStore{
+		window:     window,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		now:        time.Now,
+	}
+}
+
+// Seen reports whether key was marked completed within the window
+func (s *MemoryDedupStore) Seen(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.entries[key]
+	if !ok {
+		return false, nil
+	}
+	if s.now().Sub(el.Value.(*memoryDedupEntry).completedAt) >= s.window {
+		s.order.Remove(el)
+		delete(s.entries, key)
+		return false, nil
+	}
+	return true, nil
+}
+
+// MarkCompleted records that the message with key was sent
+func (s *MemoryDedupStore) MarkCompleted(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.entries[key]; ok {
+		el.Value.(*memoryDedupEntry).completedAt = s.now()
+		s.order.MoveToFront(el)
+		return nil
+	}
+
+	s.entries[key] = s.order.PushFront(&memoryDedupEntry{key: key, completedAt: s.now()})
+	for s.maxEntries > 0 && s.order.Len() > s.maxEntries {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*memoryDedupEntry).key)
+	}
+	return nil
+}
+
+// Close releases resources held by the store
+func (s *MemoryDedupStore) Close() error {
+	return nil
+}
@@ -0,0 +1,72 @@
+package worker
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/starline/rabbitmq-worker/internal/boltbucket"
+)
+
+// BoltDedupStore keeps completed keys in an embedded bbolt database so they
+// survive restarts
+type BoltDedupStore struct {
+	bucket *boltbucket.Bucket
+	window time.Duration
+	now    func() time.Time
+}
+
+// OpenBoltDedupStore opens or creates the database at path and starts
+// removing keys older than window in the background
+func OpenBoltDedupStore(path string, window time.Duration) (*BoltDedupStore, error) {
+	s := &BoltDedupStore{
+		window: window,
+		now:    time.Now,
+	}
+	bucket, err := boltbucket.Open(path, "dedup", completedAt, s.cutoff)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dedup database: %w", err)
+	}
+	s.bucket = bucket
+	return s, nil
+}
+
+// Seen reports whether key was marked completed within the window
+func (s *BoltDedupStore) Seen(key string) (bool, error) {
+	v, err := s.bucket.Get(key)
+	if err != nil {
+		return false, err
+	}
+	at, ok := completedAt(v)
+	return ok && at.After(s.cutoff()), nil
+}
+
+// MarkCompleted records that the message with key was sent
+func (s *BoltDedupStore) MarkCompleted(key string) error {
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(s.now().UnixNano()))
+	return s.bucket.Put(key, v)
+}
+
+// Close stops the background sweep and closes the database
+func (s *BoltDedupStore) Close() error {
+	return s.bucket.Close()
+}
+
+// sweep removes keys completed before the window
+func (s *BoltDedupStore) sweep() error {
+	return s.bucket.Sweep()
+}
+
+// cutoff returns the time keys completed before are forgotten
+func (s *BoltDedupStore) cutoff() time.Time {
+	return s.now().Add(-s.window)
+}
+
+// completedAt decodes the time a key was marked completed
+func completedAt(v []byte) (time.Time, bool) {
+	if len(v) != 8 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(v))), true
+}
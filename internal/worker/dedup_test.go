@@ -0,0 +1,139 @@
+package worker
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/starline/rabbitmq-worker/internal/config"
+)
+
+func TestMemoryDedupStore(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryDedupStore(time.Minute, 2)
+	store.now = func() time.Time { return now }
+
+	store.MarkCompleted("a")
+	if seen, _ := store.Seen("a"); !seen {
+		t.Error("expected 'a' to be seen")
+	}
+	if seen, _ := store.Seen("b"); seen {
+		t.Error("expected 'b' not to be seen")
+	}
+
+	// Oldest key is evicted once the store is full
+	store.MarkCompleted("b")
+	store.MarkCompleted("c")
+	if seen, _ := store.Seen("a"); seen {
+		t.Error("expected 'a' to be evicted")
+	}
+
+	// Keys expire after the window
+	now = now.Add(2 * time.Minute)
+	if seen, _ := store.Seen("c"); seen {
+		t.Error("expected 'c' to expire")
+	}
+}
+
+func TestBoltDedupStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.db")
+
+	store, err := OpenBoltDedupStore(path, time.Minute)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	if err := store.MarkCompleted("a"); err != nil {
+		t.Fatalf("failed to mark key: %v", err)
+	}
+	store.Close()
+
+	// Keys survive reopening the database
+	store, err = OpenBoltDedupStore(path, time.Minute)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+
+	if seen, err := store.Seen("a"); err != nil || !seen {
+		t.Errorf("expected 'a' to be seen, got %v, %v", seen, err)
+	}
+
+	store.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	if seen, _ := store.Seen("a"); seen {
+		t.Error("expected 'a' to expire")
+	}
+	if err := store.sweep(); err != nil {
+		t.Fatalf("failed to sweep: %v", err)
+	}
+	store.now = time.Now
+	if seen, _ := store.Seen("a"); seen {
+		t.Error("expected 'a' to be swept")
+	}
+}
+
+func TestOpenDedupStore(t *testing.T) {
+	if _, err := OpenDedupStore(&config.DedupConfig{Backend: "redis"}); err == nil {
+		t.Error("expected error for unknown backend")
+	}
+
+	store, err := OpenDedupStore(&config.DedupConfig{Backend: "none"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	store.MarkCompleted("a")
+	if seen, _ := store.Seen("a"); seen {
+		t.Error("expected disabled store never to report keys as seen")
+	}
+}
+
+func TestDedupKey(t *testing.T) {
+	msg := Message{Recipient: "79218897127", Body: "code 1234"}
+	ts := time.Unix(1700000000, 0)
+
+	if got := dedupKey(amqp.Delivery{MessageId: "abc"}, 1, msg); got != "abc#1" {
+		t.Errorf("expected 'abc#1', got '%s'", got)
+	}
+	withID := Message{ID: "otp-42", Recipient: msg.Recipient, Body: msg.Body}
+	if got := dedupKey(amqp.Delivery{MessageId: "abc"}, 1, withID); got != "id:otp-42" {
+		t.Errorf("expected message id to win, got '%s'", got)
+	}
+
+	a := dedupKey(amqp.Delivery{Timestamp: ts}, 0, msg)
+	b := dedupKey(amqp.Delivery{Timestamp: ts.Add(time.Second)}, 0, msg)
+	if a == b {
+		t.Error("expected keys with different timestamps to differ")
+	}
+	if a != dedupKey(amqp.Delivery{Timestamp: ts}, 0, msg) {
+		t.Error("expected key to be stable")
+	}
+}
+
+func TestHandleDeliverySkipsDuplicates(t *testing.T) {
+	var sent int32
+	w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&sent, 1)
+		w.WriteHeader(http.StatusOK)
+	})
+	w.dedup = NewMemoryDedupStore(time.Hour, 10)
+
+	ack := &fakeAcknowledger{}
+	d := amqp.Delivery{
+		Acknowledger: ack,
+		MessageId:    "otp-1",
+		Body:         []byte(`{"messages":[{"recipient":"79218897127","body":"test"}]}`),
+	}
+	w.handleDelivery(context.Background(), d)
+	w.handleDelivery(context.Background(), d) // redelivery
+
+	if sent != 1 {
+		t.Errorf("expected 1 API call, got %d", sent)
+	}
+	if len(ack.acks) != 2 {
+		t.Errorf("expected both deliveries to be acked, got %v", ack.acks)
+	}
+}
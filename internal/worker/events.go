@@ -0,0 +1,220 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+
+	"github.com/starline/rabbitmq-worker/internal/dlr"
+	"github.com/starline/rabbitmq-worker/internal/logging"
+	"github.com/starline/rabbitmq-worker/internal/metrics"
+)
+
+// Message statuses reported in status events. Delivery reports add
+// dlr.StatusDelivered, dlr.StatusUndelivered and dlr.StatusExpired.
+const (
+	StatusAccepted = "accepted"
+	StatusSent     = "sent"
+	StatusFailed   = "failed"
+	StatusExpired  = "expired"
+)
+
+// statusEventType is the AMQP type of status events
+const statusEventType = "sms.status"
+
+// StatusEvent reports the progress of a message to its producer
+type StatusEvent struct {
+	Status string `json:"status"`
+	// ID is the id field of the message
+	ID string `json:"id,omitempty"`
+	// MessageID is the AMQP message-id of the delivery the message came in
+	MessageID         string `json:"message_id,omitempty"`
+	Type              string `json:"type,omitempty"`
+	Recipient         string `json:"recipient,omitempty"`
+	Provider          string `json:"provider,omitempty"`
+	ProviderMessageID string `json:"provider_message_id,omitempty"`
+	// Reason is the dead-letter reason of a failed message
+	Reason string    `json:"reason,omitempty"`
+	Error  string    `json:"error,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// confirmPublisher publishes on a channel in confirm mode and waits until
+// the broker confirms each message
+type confirmPublisher struct {
+	ch      *amqp.Channel
+	timeout time.Duration
+}
+
+// PublishWithContext publishes a message and waits for its confirmation
+func (p *confirmPublisher) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	confirm, err := p.ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, mandatory, immediate, msg)
+	if err != nil {
+		return err
+	}
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("no publisher confirm: %w", err)
+	}
+	if !acked {
+		return errors.New("message nacked by broker")
+	}
+	return nil
+}
+
+// openEventsChannel opens the channel status events are published on and
+// declares their exchange. Nothing is opened when events are disabled.
+func (w *Worker) openEventsChannel(conn *amqp.Connection) error {
+	events := w.config.RabbitMQ.Events
+	if events.Exchange == "" {
+		return nil
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open channel: %w", err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+	if err := ch.ExchangeDeclare(
+		events.Exchange, // name
+		"topic",         // kind
+		true,            // durable
+		false,           // auto-delete
+		false,           // internal
+		false,           // no-wait
+		nil,             // arguments
+	); err != nil {
+		ch.Close()
+		return fmt.Errorf("failed to declare exchange %s: %w", events.Exchange, err)
+	}
+
+	w.setEventsChannel(ch, &confirmPublisher{ch: ch, timeout: events.ConfirmTimeout})
+	return nil
+}
+
+// closeEventsChannel stops publishing status events until the next connection
+func (w *Worker) closeEventsChannel() {
+	ch := w.setEventsChannel(nil, nil)
+	if ch != nil {
+		if err := ch.Close(); err != nil && !errors.Is(err, amqp.ErrClosed) {
+			logging.Error("failed to close status events channel", err)
+		}
+	}
+}
+
+// setEventsChannel replaces the channel and publisher of status events and
+// returns the previous channel. Delivery reports publish from HTTP handlers
+// while the supervisor reconnects and Stop closes the channel, so both are
+// guarded by a lock.
+func (w *Worker) setEventsChannel(ch *amqp.Channel, p publisher) *amqp.Channel {
+	w.eventsMu.Lock()
+	defer w.eventsMu.Unlock()
+	prev := w.eventsChannel
+	w.eventsChannel = ch
+	w.events = p
+	return prev
+}
+
+// eventsClosed returns a channel notified when the status events channel is
+// closed, nil when status events are disabled
+func (w *Worker) eventsClosed() chan *amqp.Error {
+	w.eventsMu.RLock()
+	defer w.eventsMu.RUnlock()
+	if w.eventsChannel == nil {
+		return nil
+	}
+	return w.eventsChannel.NotifyClose(make(chan *amqp.Error, 1))
+}
+
+// eventPublisher returns the publisher of status events, nil if disabled or
+// disconnected
+func (w *Worker) eventPublisher() publisher {
+	w.eventsMu.RLock()
+	defer w.eventsMu.RUnlock()
+	return w.events
+}
+
+// publishStatus publishes a status event of a message in a delivery. A lost
+// event is logged but doesn't fail the message.
+func (w *Worker) publishStatus(delivery amqp.Delivery, msg Message, event StatusEvent) {
+	event.ID = msg.ID
+	event.MessageID = delivery.MessageId
+	event.Type = msg.Type
+	event.Recipient = msg.Recipient
+	w.publishEvent(event, delivery.CorrelationId)
+}
+
+// publishDeliveryReport publishes the status a delivery report set for a
+// sent message
+func (w *Worker) publishDeliveryReport(rec dlr.Record) {
+	w.publishEvent(StatusEvent{
+		Status:            rec.Status,
+		ID:                rec.ID,
+		MessageID:         rec.MessageID,
+		Type:              rec.Type,
+		Recipient:         rec.Recipient,
+		Provider:          rec.Provider,
+		ProviderMessageID: rec.ProviderMessageID,
+	}, rec.CorrelationID)
+}
+
+// publishEvent publishes a status event to the events exchange with a
+// routing key of "<prefix>.<status>"
+func (w *Worker) publishEvent(event StatusEvent, correlationID string) {
+	p := w.eventPublisher()
+	if p == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	fields := logrus.Fields{
+		"status":     event.Status,
+		"message_id": event.MessageID,
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		logging.Error("failed to encode status event", err, fields)
+		return
+	}
+
+	events := w.config.RabbitMQ.Events
+	err = p.PublishWithContext(
+		context.Background(),
+		events.Exchange, // exchange
+		events.RoutingKeyPrefix+"."+event.Status, // routing key
+		false, // mandatory
+		false, // immediate
+		amqp.Publishing{
+			ContentType:   "application/json",
+			DeliveryMode:  amqp.Persistent,
+			MessageId:     event.MessageID,
+			CorrelationId: correlationID,
+			Timestamp:     event.Time,
+			Type:          statusEventType,
+			Body:          body,
+		},
+	)
+	if err != nil {
+		logging.Error("failed to publish status event", err, fields)
+		metrics.StatusEvents.WithLabelValues(event.Status, "failure").Inc()
+		return
+	}
+	metrics.StatusEvents.WithLabelValues(event.Status, "success").Inc()
+}
@@ -0,0 +1,196 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/starline/rabbitmq-worker/internal/dlr"
+)
+
+// newEventsTestWorker returns a test worker publishing status events to a fake
+func newEventsTestWorker(t *testing.T, handler http.HandlerFunc) (*Worker, *fakePublisher) {
+	w := newTestWorker(t, handler)
+	w.config.RabbitMQ.Events.Exchange = "sms.events"
+	w.config.RabbitMQ.Events.RoutingKeyPrefix = "sms.status"
+	events := &fakePublisher{}
+	w.setEventsChannel(nil, events)
+	return w, events
+}
+
+// statusEvents decodes the published events
+func statusEvents(t *testing.T, pub *fakePublisher) []StatusEvent {
+	t.Helper()
+	events := make([]StatusEvent, len(pub.published))
+	for i, p := range pub.published {
+		if p.exchange != "sms.events" {
+			t.Errorf("unexpected exchange %s", p.exchange)
+		}
+		if err := json.Unmarshal(p.msg.Body, &events[i]); err != nil {
+			t.Fatalf("failed to decode event: %v", err)
+		}
+		if p.key != "sms.status."+events[i].Status {
+			t.Errorf("expected routing key sms.status.%s, got %s", events[i].Status, p.key)
+		}
+	}
+	return events
+}
+
+func TestStatusEventsSent(t *testing.T) {
+	w, pub := newEventsTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "OK: 12345")
+	})
+
+	w.handleDelivery(context.Background(), amqp.Delivery{
+		Acknowledger:  &fakeAcknowledger{},
+		MessageId:     "msg-1",
+		CorrelationId: "corr-1",
+		Body:          []byte(`{"messages":[{"id":"a1","type":"otp","recipient":"79218897127","body":"test"}]}`),
+	})
+
+	events := statusEvents(t, pub)
+	if len(events) != 2 || events[0].Status != StatusAccepted || events[1].Status != StatusSent {
+		t.Fatalf("expected accepted and sent events, got %+v", events)
+	}
+	sent := events[1]
+	if sent.ID != "a1" || sent.MessageID != "msg-1" || sent.Type != TypeOTP || sent.ProviderMessageID != "12345" {
+		t.Errorf("unexpected sent event %+v", sent)
+	}
+	for _, p := range pub.published {
+		if p.msg.MessageId != "msg-1" || p.msg.CorrelationId != "corr-1" {
+			t.Errorf("expected original message and correlation IDs, got %q %q", p.msg.MessageId, p.msg.CorrelationId)
+		}
+	}
+}
+
+func TestStatusEventsRetried(t *testing.T) {
+	w, pub := newEventsTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	delivery := amqp.Delivery{
+		Acknowledger: &fakeAcknowledger{},
+		MessageId:    "msg-1",
+		Body:         []byte(`{"messages":[{"recipient":"79218897127","body":"test"}]}`),
+	}
+
+	// A transient failure is retried, so the message hasn't failed yet
+	w.handleDelivery(context.Background(), delivery)
+	if events := statusEvents(t, pub); len(events) != 1 || events[0].Status != StatusAccepted {
+		t.Fatalf("expected only an accepted event, got %+v", events)
+	}
+
+	// The last attempt is neither accepted again nor retried
+	pub.published = nil
+	delivery.Headers = amqp.Table{retryCountHeader: int32(2)}
+	w.handleDelivery(context.Background(), delivery)
+	events := statusEvents(t, pub)
+	if len(events) != 1 || events[0].Status != StatusFailed || events[0].Reason != reasonTransient {
+		t.Fatalf("expected a failed event, got %+v", events)
+	}
+}
+
+func TestStatusEventsFailedAndExpired(t *testing.T) {
+	w, pub := newEventsTestWorker(t, func(w http.ResponseWriter, r *http.Request) {})
+
+	w.handleDelivery(context.Background(), amqp.Delivery{
+		Acknowledger: &fakeAcknowledger{},
+		Timestamp:    time.Now().Add(-time.Hour),
+		Body: []byte(`{"messages":[
+			{"recipient":"79218897127","body":"test","sender":"A","source":"B"},
+			{"recipient":"79218897127","body":"test","ttl":60}
+		]}`),
+	})
+
+	events := statusEvents(t, pub)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %+v", events)
+	}
+	if events[0].Status != StatusFailed || events[0].Reason != reasonInvalid || events[0].Error == "" {
+		t.Errorf("expected failed event for the invalid message, got %+v", events[0])
+	}
+	if events[1].Status != StatusExpired {
+		t.Errorf("expected expired event, got %+v", events[1])
+	}
+}
+
+func TestStatusEventsFailedDelivery(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"invalid payload", "application/json", `not json`},
+		{"unsupported content type", "text/plain", `hello`},
+		{"undecodable protobuf", "application/x-protobuf", `not protobuf`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w, pub := newEventsTestWorker(t, func(w http.ResponseWriter, r *http.Request) {})
+
+			w.handleDelivery(context.Background(), amqp.Delivery{
+				Acknowledger:  &fakeAcknowledger{},
+				MessageId:     "m1",
+				CorrelationId: "c1",
+				ContentType:   test.contentType,
+				Body:          []byte(test.body),
+			})
+
+			events := statusEvents(t, pub)
+			if len(events) != 1 {
+				t.Fatalf("expected 1 event, got %+v", events)
+			}
+			if events[0].Status != StatusFailed || events[0].MessageID != "m1" ||
+				events[0].Reason == "" || events[0].Error == "" {
+				t.Errorf("expected failed event for the delivery, got %+v", events[0])
+			}
+			if got := pub.published[0].msg.CorrelationId; got != "c1" {
+				t.Errorf("expected correlation id c1, got %s", got)
+			}
+		})
+	}
+}
+
+func TestStatusEventsDeliveryReport(t *testing.T) {
+	w, pub := newEventsTestWorker(t, func(w http.ResponseWriter, r *http.Request) {})
+
+	w.reports.Track(dlr.Record{
+		ProviderMessageID: "12345",
+		MessageID:         "msg-1",
+		CorrelationID:     "corr-1",
+		Type:              TypeOTP,
+	})
+	w.reports.Update("12345", dlr.StatusDelivered)
+
+	events := statusEvents(t, pub)
+	if len(events) != 1 || events[0].Status != dlr.StatusDelivered || events[0].ProviderMessageID != "12345" {
+		t.Fatalf("expected delivered event, got %+v", events)
+	}
+	if pub.published[0].msg.CorrelationId != "corr-1" {
+		t.Errorf("expected correlation ID corr-1, got %q", pub.published[0].msg.CorrelationId)
+	}
+}
+
+func TestStatusEventsPublishFailure(t *testing.T) {
+	w, pub := newEventsTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "OK: 12345")
+	})
+	pub.err = errors.New("channel closed")
+
+	ack := &fakeAcknowledger{}
+	w.handleDelivery(context.Background(), amqp.Delivery{
+		Acknowledger: ack,
+		Body:         []byte(`{"messages":[{"recipient":"79218897127","body":"test"}]}`),
+	})
+
+	if len(ack.acks) != 1 {
+		t.Errorf("expected message to be acked despite lost events, got %v", ack.acks)
+	}
+}
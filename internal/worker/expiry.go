@@ -0,0 +1,74 @@
+package worker
+
+import (
+	"strconv"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// defaultMaxAgeKey is the max age entry for messages whose type has no
+// entry of its own
+const defaultMaxAgeKey = "default"
+
+// expiry returns the time after which a message is stale and must not be
+// sent, or the zero time if it never expires. The earliest of the AMQP
+// expiration, the configured max age for the message type and the message's
+// own expires_at and ttl wins.
+func (w *Worker) expiry(delivery amqp.Delivery, msg Message) time.Time {
+	sent := sentAt(delivery)
+	expiry := msg.Expiry(sent)
+
+	earliest := func(t time.Time) {
+		if expiry.IsZero() || t.Before(expiry) {
+			expiry = t
+		}
+	}
+
+	// Without a timestamp the age of the message is unknown
+	if !delivery.Timestamp.IsZero() {
+		if ttl, ok := amqpExpiration(delivery); ok {
+			earliest(delivery.Timestamp.Add(ttl))
+		}
+		if maxAge := w.maxAge(msg.Type); maxAge > 0 {
+			earliest(delivery.Timestamp.Add(maxAge))
+		}
+	}
+	return expiry
+}
+
+// maxAge returns the configured max age for a message type
+func (w *Worker) maxAge(msgType string) time.Duration {
+	maxAge := w.config.Expiry.MaxAge
+	if age, ok := maxAge[msgType]; ok && msgType != "" {
+		return age
+	}
+	return maxAge[defaultMaxAgeKey]
+}
+
+// amqpExpiration returns the per-message TTL the publisher set. Retried
+// messages carry it in a header because the retry queues have their own TTL.
+func amqpExpiration(delivery amqp.Delivery) (time.Duration, bool) {
+	expiration := delivery.Expiration
+	if expiration == "" {
+		expiration, _ = delivery.Headers[expirationHeader].(string)
+	}
+	if expiration == "" {
+		return 0, false
+	}
+
+	ms, err := strconv.ParseInt(expiration, 10, 64)
+	if err != nil || ms < 0 {
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+// sentAt returns the time a delivery was published, or now if the publisher
+// didn't set a timestamp
+func sentAt(delivery amqp.Delivery) time.Time {
+	if delivery.Timestamp.IsZero() {
+		return time.Now()
+	}
+	return delivery.Timestamp
+}
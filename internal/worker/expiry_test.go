@@ -0,0 +1,104 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/starline/rabbitmq-worker/internal/api"
+)
+
+func TestExpiry(t *testing.T) {
+	w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {})
+	w.config.Expiry.MaxAge = map[string]time.Duration{
+		TypeOTP:          5 * time.Minute,
+		defaultMaxAgeKey: time.Hour,
+		TypeNotification: 0,
+	}
+
+	sent := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := sent.Add(d)
+		return &t
+	}
+
+	tests := []struct {
+		name     string
+		delivery amqp.Delivery
+		msg      Message
+		expected time.Time
+	}{
+		{"no timestamp", amqp.Delivery{}, Message{Type: TypeOTP}, time.Time{}},
+		{"otp max age", amqp.Delivery{Timestamp: sent}, Message{Type: TypeOTP}, sent.Add(5 * time.Minute)},
+		{"default max age", amqp.Delivery{Timestamp: sent}, Message{}, sent.Add(time.Hour)},
+		{"unlisted type", amqp.Delivery{Timestamp: sent}, Message{Type: TypeMarketing}, sent.Add(time.Hour)},
+		{"unlimited type", amqp.Delivery{Timestamp: sent}, Message{Type: TypeNotification}, time.Time{}},
+		{"amqp expiration", amqp.Delivery{Timestamp: sent, Expiration: "60000"}, Message{Type: TypeOTP}, sent.Add(time.Minute)},
+		{"retried expiration", amqp.Delivery{
+			Timestamp: sent,
+			Headers:   amqp.Table{expirationHeader: "60000"},
+		}, Message{Type: TypeOTP}, sent.Add(time.Minute)},
+		{"bad expiration", amqp.Delivery{Timestamp: sent, Expiration: "soon"}, Message{Type: TypeOTP}, sent.Add(5 * time.Minute)},
+		{"expires_at", amqp.Delivery{Timestamp: sent}, Message{Type: TypeOTP, ExpiresAt: at(time.Minute)}, sent.Add(time.Minute)},
+		{"ttl", amqp.Delivery{Timestamp: sent}, Message{TTL: 30}, sent.Add(30 * time.Second)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := w.expiry(test.delivery, test.msg); !got.Equal(test.expected) {
+				t.Errorf("expected expiry %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestHandleDeliveryDropsExpired(t *testing.T) {
+	var sent int
+	w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		sent++
+		w.WriteHeader(http.StatusOK)
+	})
+	w.config.Expiry.MaxAge = map[string]time.Duration{TypeOTP: 5 * time.Minute}
+
+	ack := &fakeAcknowledger{}
+	w.handleDelivery(context.Background(), amqp.Delivery{
+		Acknowledger: ack,
+		Timestamp:    time.Now().Add(-10 * time.Minute),
+		Body: []byte(`{"messages":[
+			{"recipient":"79218897127","body":"Код: 2652","type":"otp"},
+			{"recipient":"79218897127","body":"Машина на охране","type":"notification"}
+		]}`),
+	})
+
+	if sent != 1 {
+		t.Errorf("expected only the fresh message to be sent, got %d", sent)
+	}
+	if pub := w.publisher.(*fakePublisher); len(pub.published) != 0 {
+		t.Errorf("expected expired message to be dropped, got %+v", pub.published)
+	}
+	if len(ack.acks) != 1 {
+		t.Errorf("expected delivery to be acked, got %v", ack.acks)
+	}
+}
+
+func TestRetryKeepsExpiration(t *testing.T) {
+	w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {})
+
+	d := amqp.Delivery{Expiration: "60000", Body: []byte(`{}`)}
+	if err := w.retryOrDeadLetter(d, &api.ErrTransient{Err: errors.New("timeout")}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pub := w.publisher.(*fakePublisher)
+	if len(pub.published) != 1 {
+		t.Fatalf("expected one retry, got %+v", pub.published)
+	}
+	p := pub.published[0]
+	if p.msg.Expiration != "" || p.msg.Headers[expirationHeader] != "60000" {
+		t.Errorf("expected expiration to move to header, got '%s' %v", p.msg.Expiration, p.msg.Headers)
+	}
+}
@@ -0,0 +1,56 @@
+package worker
+
+import (
+	"github.com/sirupsen/logrus"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/starline/rabbitmq-worker/internal/logging"
+	"github.com/starline/rabbitmq-worker/internal/metrics"
+	"github.com/starline/rabbitmq-worker/internal/schema"
+)
+
+const (
+	// schemaVersionHeader names the schema version a producer wrote the
+	// payload for, schema.DefaultVersion if missing
+	schemaVersionHeader = "x-schema-version"
+	// invalidReasonHeader tells whether the payload is not JSON, doesn't match
+	// the schema or names an unknown schema version
+	invalidReasonHeader = "x-invalid-reason"
+	// validationErrorsHeader lists the validation errors of an invalid payload
+	validationErrorsHeader = "x-validation-errors"
+)
+
+// schemaVersion returns the schema version requested by a delivery
+func schemaVersion(d amqp.Delivery) string {
+	version, _ := d.Headers[schemaVersionHeader].(string)
+	return version
+}
+
+// rejectInvalid moves a delivery whose payload failed validation to the
+// invalid queue, where producers can inspect it. It is never retried since
+// the payload won't change.
+func (w *Worker) rejectInvalid(d amqp.Delivery, invalid *schema.ErrInvalid) error {
+	errs := make([]interface{}, len(invalid.Errors))
+	for i, e := range invalid.Errors {
+		errs[i] = e
+	}
+
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[schemaVersionHeader] = invalid.Version
+	headers[invalidReasonHeader] = invalid.Reason
+	headers[validationErrorsHeader] = errs
+
+	logging.Warn("invalid message payload", logrus.Fields{
+		"message_id": d.MessageId,
+		"app_id":     d.AppId,
+		"version":    invalid.Version,
+		"reason":     invalid.Reason,
+		"errors":     invalid.Errors,
+	})
+	metrics.MessagesMalformed.WithLabelValues(invalid.Reason).Inc()
+	return w.republish(d, w.config.RabbitMQ.Retry.InvalidQueue, headers)
+}
@@ -1,12 +1,128 @@
 package worker
 
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/starline/rabbitmq-worker/internal/translit"
+)
+
+// Message types
+const (
+	TypeOTP          = "otp"
+	TypeNotification = "notification"
+	TypeMarketing    = "marketing"
+)
+
+// maxPriority is the highest AMQP message priority
+const maxPriority = 9
+
 // MessageRequest represents incoming message from RabbitMQ
 type MessageRequest struct {
 	Messages []Message `json:"messages"`
 }
 
-// Message represents a single message to be sent
+// Message represents a single message to be sent. Only Recipient and either
+// Body or Template are required.
 type Message struct {
+	// ID identifies the message across retries and redeliveries
+	ID        string `json:"id,omitempty"`
 	Recipient string `json:"recipient"`
-	Body      string `json:"body"`
-}
\ No newline at end of file
+	// Body is omitted when empty so that a retried template message still
+	// passes the schema, which requires a non-empty body when one is given
+	Body string `json:"body,omitempty"`
+	// Template is rendered with Params into the body when Body is empty
+	Template string         `json:"template,omitempty"`
+	Params   map[string]any `json:"params,omitempty"`
+	// Locale selects the template variant, e.g. "ru" or "en"
+	Locale string `json:"locale,omitempty"`
+	// Transliterate overrides the transliteration policy for this message
+	Transliterate string `json:"transliterate,omitempty"`
+	// Type is one of otp, notification or marketing
+	Type string `json:"type,omitempty"`
+	// Priority from 0 to 9, higher is more urgent
+	Priority int `json:"priority,omitempty"`
+	// ExpiresAt is the time after which the message is no longer sent
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// TTL is the message lifetime in seconds from the delivery timestamp
+	TTL int `json:"ttl,omitempty"`
+	// Source overrides the configured sender name, Sender is an alias for it
+	Source      string   `json:"source,omitempty"`
+	Sender      string   `json:"sender,omitempty"`
+	CallbackURL string   `json:"callback_url,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+// ErrInvalidMessage is returned for a message with a malformed field
+type ErrInvalidMessage struct {
+	Field  string
+	Reason string
+}
+
+func (e *ErrInvalidMessage) Error() string {
+	return fmt.Sprintf("invalid message field %s: %s", e.Field, e.Reason)
+}
+
+// Validate checks the optional fields of the message
+func (m *Message) Validate() error {
+	switch m.Type {
+	case "", TypeOTP, TypeNotification, TypeMarketing:
+	default:
+		return &ErrInvalidMessage{Field: "type", Reason: fmt.Sprintf("unknown type %q", m.Type)}
+	}
+
+	if m.Transliterate != "" && !translit.IsPolicy(m.Transliterate) {
+		return &ErrInvalidMessage{Field: "transliterate", Reason: fmt.Sprintf("unknown policy %q", m.Transliterate)}
+	}
+
+	if m.Priority < 0 || m.Priority > maxPriority {
+		return &ErrInvalidMessage{Field: "priority", Reason: fmt.Sprintf("must be between 0 and %d", maxPriority)}
+	}
+	if m.TTL < 0 {
+		return &ErrInvalidMessage{Field: "ttl", Reason: "must not be negative"}
+	}
+
+	if m.Source != "" && m.Sender != "" && m.Source != m.Sender {
+		return &ErrInvalidMessage{Field: "sender", Reason: "differs from source"}
+	}
+
+	if m.CallbackURL != "" {
+		u, err := url.Parse(m.CallbackURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return &ErrInvalidMessage{Field: "callback_url", Reason: "must be an absolute http or https URL"}
+		}
+	}
+
+	for _, tag := range m.Tags {
+		if tag == "" {
+			return &ErrInvalidMessage{Field: "tags", Reason: "must not be empty"}
+		}
+	}
+	return nil
+}
+
+// SenderName returns the sender name override, empty to use the configured one
+func (m *Message) SenderName() string {
+	if m.Source != "" {
+		return m.Source
+	}
+	return m.Sender
+}
+
+// Expiry returns the time after which the message must not be sent, or the
+// zero time if it doesn't expire. TTL counts from sent, the time the message
+// was published.
+func (m *Message) Expiry(sent time.Time) time.Time {
+	var expiry time.Time
+	if m.ExpiresAt != nil {
+		expiry = *m.ExpiresAt
+	}
+	if m.TTL > 0 {
+		ttlExpiry := sent.Add(time.Duration(m.TTL) * time.Second)
+		if expiry.IsZero() || ttlExpiry.Before(expiry) {
+			expiry = ttlExpiry
+		}
+	}
+	return expiry
+}
@@ -0,0 +1,364 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+
+	"github.com/starline/rabbitmq-worker/internal/api"
+	"github.com/starline/rabbitmq-worker/internal/logging"
+	"github.com/starline/rabbitmq-worker/internal/metrics"
+	"github.com/starline/rabbitmq-worker/internal/phone"
+	"github.com/starline/rabbitmq-worker/internal/ratelimit"
+	"github.com/starline/rabbitmq-worker/internal/schema"
+	"github.com/starline/rabbitmq-worker/internal/segment"
+	"github.com/starline/rabbitmq-worker/internal/templates"
+)
+
+const (
+	// retryCountHeader holds the number of retries already made for a message
+	retryCountHeader = "x-retry-count"
+	// throttleCountHeader holds the number of times a message was delayed by
+	// our own rate limits, which don't use up its attempts
+	throttleCountHeader = "x-throttle-count"
+	// deadReasonHeader holds a short code explaining why a message was dead-lettered
+	deadReasonHeader = "x-dead-letter-reason"
+	// deadErrorHeader holds the error that caused a message to be dead-lettered
+	deadErrorHeader = "x-dead-letter-error"
+	// expirationHeader keeps the AMQP expiration of a retried message, which
+	// can't stay in the properties since the retry queue would drop it early
+	expirationHeader = "x-original-expiration"
+)
+
+// Dead-letter reasons
+const (
+	reasonRejected    = "rejected"
+	reasonAuth        = "auth"
+	reasonRateLimited = "rate_limited"
+	reasonCircuitOpen = "circuit_open"
+	reasonThrottled   = "throttled"
+	reasonRecipient   = "invalid_recipient"
+	reasonTooLong     = "too_long"
+	reasonTemplate    = "template"
+	reasonInvalid     = "invalid_message"
+	reasonContentType = "unsupported_content_type"
+	reasonTransient   = "transient"
+	reasonError       = "error"
+)
+
+// failedMessage is an item of a request that has to be retried or dead-lettered
+type failedMessage struct {
+	delivery amqp.Delivery
+	err      error
+}
+
+// publisher publishes messages to RabbitMQ, implemented by *amqp.Channel
+type publisher interface {
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+// declareRetryTopology declares the dead-letter exchange, one delay queue per
+// retry delay, the final dead-letter queue and the queue for invalid payloads.
+//
+// A failed message is published to the delay queue matching its attempt. When
+// its TTL expires RabbitMQ dead-letters it back to the main queue through the
+// default exchange.
+func (w *Worker) declareRetryTopology(ch *amqp.Channel) error {
+	queue := w.config.RabbitMQ.Queue
+	retry := w.config.RabbitMQ.Retry
+
+	if err := ch.ExchangeDeclare(
+		retry.Exchange, // name
+		"direct",       // kind
+		true,           // durable
+		false,          // auto-delete
+		false,          // internal
+		false,          // no-wait
+		nil,            // arguments
+	); err != nil {
+		return fmt.Errorf("failed to declare exchange %s: %w", retry.Exchange, err)
+	}
+
+	for i, delay := range retry.Delays {
+		name := w.retryQueueName(i)
+		args := w.queueArgs(amqp.Table{
+			"x-message-ttl":             delay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queue,
+		})
+		if _, err := ch.QueueDeclare(name, true, false, false, false, args); err != nil {
+			return fmt.Errorf("failed to declare retry queue %s: %w", name, err)
+		}
+		if err := ch.QueueBind(name, name, retry.Exchange, false, nil); err != nil {
+			return fmt.Errorf("failed to bind retry queue %s: %w", name, err)
+		}
+	}
+
+	if _, err := ch.QueueDeclare(retry.DeadQueue, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare dead-letter queue %s: %w", retry.DeadQueue, err)
+	}
+	if err := ch.QueueBind(retry.DeadQueue, retry.DeadQueue, retry.Exchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind dead-letter queue %s: %w", retry.DeadQueue, err)
+	}
+
+	if _, err := ch.QueueDeclare(retry.InvalidQueue, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare invalid queue %s: %w", retry.InvalidQueue, err)
+	}
+	if err := ch.QueueBind(retry.InvalidQueue, retry.InvalidQueue, retry.Exchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind invalid queue %s: %w", retry.InvalidQueue, err)
+	}
+
+	return nil
+}
+
+// queueArgs adds x-max-priority to the arguments of the main and retry
+// queues when priorities are enabled
+func (w *Worker) queueArgs(args amqp.Table) amqp.Table {
+	maxPriority := w.config.RabbitMQ.MaxPriority
+	if maxPriority <= 0 {
+		return args
+	}
+	if args == nil {
+		args = amqp.Table{}
+	}
+	args["x-max-priority"] = int32(maxPriority)
+	return args
+}
+
+// retryQueueName returns the name of the delay queue for the given retry index
+func (w *Worker) retryQueueName(i int) string {
+	return fmt.Sprintf("%s.retry.%d", w.config.RabbitMQ.Queue, i+1)
+}
+
+// retryOrDeadLetter republishes a failed delivery to the next delay queue or,
+// once all attempts are used up or the error is permanent, to the dead-letter queue
+func (w *Worker) retryOrDeadLetter(d amqp.Delivery, cause error) error {
+	var invalid *schema.ErrInvalid
+	if errors.As(cause, &invalid) {
+		return w.rejectInvalid(d, invalid)
+	}
+
+	retry := w.config.RabbitMQ.Retry
+	retries := retryCount(d.Headers)
+
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+
+	if !w.deadLettered(d, cause) {
+		i := w.retryDelayIndex(retries, cause)
+		attempt := retries + 1
+		if throttled(cause) {
+			attempt = retries
+			headers[throttleCountHeader] = int32(countHeader(d.Headers, throttleCountHeader) + 1)
+		} else {
+			headers[retryCountHeader] = int32(attempt)
+		}
+		if d.Expiration != "" {
+			headers[expirationHeader] = d.Expiration
+		}
+
+		logging.Warn("scheduling message retry", logrus.Fields{
+			"message_id": d.MessageId,
+			"attempt":    attempt,
+			"delay":      retry.Delays[i].String(),
+			"reason":     failureReason(cause),
+			"error":      cause.Error(),
+		})
+		metrics.MessagesRetried.Inc()
+		return w.republish(d, w.retryQueueName(i), headers)
+	}
+
+	headers[deadReasonHeader] = failureReason(cause)
+	headers[deadErrorHeader] = cause.Error()
+
+	logging.Error("message dead-lettered", cause, logrus.Fields{
+		"message_id": d.MessageId,
+		"attempts":   retries + 1,
+		"reason":     failureReason(cause),
+	})
+	metrics.MessagesDeadLettered.Inc()
+	return w.republish(d, retry.DeadQueue, headers)
+}
+
+// deadLettered tells whether a failed delivery goes to the dead-letter queue
+// rather than being retried. Messages delayed by our own rate limits are
+// always retried since nothing failed.
+func (w *Worker) deadLettered(d amqp.Delivery, cause error) bool {
+	if throttled(cause) {
+		return false
+	}
+	return isPermanent(cause) || retryCount(d.Headers)+1 >= w.config.RabbitMQ.Retry.MaxAttempts
+}
+
+// throttled reports whether cause is a rate limit of the delay policy
+func throttled(cause error) bool {
+	var limited *ratelimit.ErrLimited
+	return errors.As(cause, &limited) && !limited.Rejected
+}
+
+// retryDelayIndex picks the delay queue for the next retry. A rate-limited
+// failure waits at least as long as the provider or our own limiter asked for.
+func (w *Worker) retryDelayIndex(retries int, cause error) int {
+	delays := w.config.RabbitMQ.Retry.Delays
+	i := min(retries, len(delays)-1)
+
+	var retryAfter time.Duration
+	var rateLimited *api.ErrRateLimited
+	var limited *ratelimit.ErrLimited
+	switch {
+	case errors.As(cause, &rateLimited):
+		retryAfter = rateLimited.RetryAfter
+	case errors.As(cause, &limited):
+		retryAfter = limited.RetryAfter
+	}
+
+	for i < len(delays)-1 && delays[i] < retryAfter {
+		i++
+	}
+	return i
+}
+
+// isPermanent reports whether retrying cause is pointless
+func isPermanent(cause error) bool {
+	var limited *ratelimit.ErrLimited
+	if errors.As(cause, &limited) {
+		return limited.Rejected
+	}
+	var (
+		invalid *phone.ErrInvalid
+		tooLong *segment.ErrTooManySegments
+		tmpl    *templates.ErrTemplate
+		message *ErrInvalidMessage
+		codec   *ErrUnsupportedContentType
+	)
+	return errors.As(cause, &invalid) || errors.As(cause, &tooLong) || errors.As(cause, &tmpl) ||
+		errors.As(cause, &message) || errors.As(cause, &codec) || api.IsPermanent(cause)
+}
+
+// failureReason returns the dead-letter reason code for an error
+func failureReason(err error) string {
+	var (
+		rejected    *api.ErrRejected
+		auth        *api.ErrAuth
+		rateLimited *api.ErrRateLimited
+		transient   *api.ErrTransient
+		limited     *ratelimit.ErrLimited
+		invalid     *phone.ErrInvalid
+		tooLong     *segment.ErrTooManySegments
+		tmpl        *templates.ErrTemplate
+		message     *ErrInvalidMessage
+		codec       *ErrUnsupportedContentType
+	)
+	switch {
+	case errors.As(err, &invalid):
+		return reasonRecipient
+	case errors.As(err, &tooLong):
+		return reasonTooLong
+	case errors.As(err, &tmpl):
+		return reasonTemplate
+	case errors.As(err, &message):
+		return reasonInvalid
+	case errors.As(err, &codec):
+		return reasonContentType
+	case errors.As(err, &limited):
+		return reasonThrottled
+	case errors.As(err, &rejected):
+		return reasonRejected
+	case errors.As(err, &auth):
+		return reasonAuth
+	case errors.As(err, &rateLimited):
+		return reasonRateLimited
+	case errors.Is(err, api.ErrCircuitOpen):
+		return reasonCircuitOpen
+	case errors.As(err, &transient):
+		return reasonTransient
+	default:
+		return reasonError
+	}
+}
+
+// itemDelivery builds a delivery carrying only the i-th message of a request
+// so it can be retried on its own. A single-message request is returned as is.
+func itemDelivery(d amqp.Delivery, i int, msg Message, total int) (amqp.Delivery, error) {
+	if total == 1 {
+		return d, nil
+	}
+
+	body, err := json.Marshal(MessageRequest{Messages: []Message{msg}})
+	if err != nil {
+		return d, fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	item := d
+	item.Body = body
+	item.ContentType = "application/json"
+	if msg.Priority > 0 {
+		item.Priority = uint8(msg.Priority)
+	}
+	if d.MessageId != "" {
+		item.MessageId = fmt.Sprintf("%s.%d", d.MessageId, i)
+	}
+	return item, nil
+}
+
+// republish publishes a copy of the delivery with new headers to the
+// dead-letter exchange. It doesn't take the delivery context because a
+// message whose send was cancelled still has to be republished.
+func (w *Worker) republish(d amqp.Delivery, key string, headers amqp.Table) error {
+	return w.publisher.PublishWithContext(
+		context.Background(),
+		w.config.RabbitMQ.Retry.Exchange, // exchange
+		key,                              // routing key
+		false,                            // mandatory
+		false,                            // immediate
+		amqp.Publishing{
+			Headers:         headers,
+			ContentType:     d.ContentType,
+			ContentEncoding: d.ContentEncoding,
+			DeliveryMode:    amqp.Persistent,
+			CorrelationId:   d.CorrelationId,
+			ReplyTo:         d.ReplyTo,
+			MessageId:       d.MessageId,
+			Priority:        d.Priority,
+			Timestamp:       d.Timestamp,
+			Type:            d.Type,
+			AppId:           d.AppId,
+			Body:            d.Body,
+		},
+	)
+}
+
+// retryCount reads the retry counter header
+func retryCount(headers amqp.Table) int {
+	return countHeader(headers, retryCountHeader)
+}
+
+// firstAttempt reports whether a delivery is neither a retry nor a message
+// delayed by rate limits
+func firstAttempt(d amqp.Delivery) bool {
+	return retryCount(d.Headers) == 0 && countHeader(d.Headers, throttleCountHeader) == 0
+}
+
+// countHeader reads a counter header, tolerating the integer types RabbitMQ
+// may hand back
+func countHeader(headers amqp.Table, name string) int {
+	switch v := headers[name].(type) {
+	case int:
+		return v
+	case int16:
+		return int(v)
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	default:
+		return 0
+	}
+}
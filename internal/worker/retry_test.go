@@ -0,0 +1,166 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/starline/rabbitmq-worker/internal/api"
+	"github.com/starline/rabbitmq-worker/internal/ratelimit"
+	"github.com/starline/rabbitmq-worker/internal/segment"
+	"github.com/starline/rabbitmq-worker/internal/templates"
+)
+
+func TestRetryOrDeadLetter(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers amqp.Table
+		key     string
+		count   int32
+	}{
+		{"first failure", nil, "sms.retry.1", 1},
+		{"second failure", amqp.Table{retryCountHeader: int32(1)}, "sms.retry.2", 2},
+		{"attempts exhausted", amqp.Table{retryCountHeader: int64(2)}, "sms.dead", 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {})
+			pub := w.publisher.(*fakePublisher)
+
+			d := amqp.Delivery{MessageId: "id-1", Headers: test.headers, Body: []byte("{}")}
+			if err := w.retryOrDeadLetter(d, errors.New("boom")); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(pub.published) != 1 {
+				t.Fatalf("expected 1 publishing, got %d", len(pub.published))
+			}
+			p := pub.published[0]
+			if p.exchange != "sms.dlx" {
+				t.Errorf("expected exchange 'sms.dlx', got '%s'", p.exchange)
+			}
+			if p.key != test.key {
+				t.Errorf("expected routing key '%s', got '%s'", test.key, p.key)
+			}
+			if p.msg.MessageId != "id-1" {
+				t.Errorf("expected message id to be preserved, got '%s'", p.msg.MessageId)
+			}
+			if got := retryCount(p.msg.Headers); got != int(test.count) {
+				t.Errorf("expected retry count %d, got %d", test.count, got)
+			}
+			if test.key == "sms.dead" && p.msg.Headers[deadErrorHeader] != "boom" {
+				t.Errorf("expected dead-letter error 'boom', got '%v'", p.msg.Headers[deadErrorHeader])
+			}
+		})
+	}
+}
+
+func TestRetryOrDeadLetterThrottled(t *testing.T) {
+	w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {})
+	pub := w.publisher.(*fakePublisher)
+
+	// The last attempt held back by our own limits is delayed, not dead-lettered
+	d := amqp.Delivery{Headers: amqp.Table{retryCountHeader: int32(2)}, Body: []byte("{}")}
+	cause := &ratelimit.ErrLimited{Scope: ratelimit.ScopeRecipient, RetryAfter: time.Minute}
+	if err := w.retryOrDeadLetter(d, cause); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(pub.published) != 1 {
+		t.Fatalf("expected 1 publishing, got %d", len(pub.published))
+	}
+	p := pub.published[0]
+	if p.key != "sms.retry.2" {
+		t.Errorf("expected throttled message to be delayed, got '%s'", p.key)
+	}
+	if got := retryCount(p.msg.Headers); got != 2 {
+		t.Errorf("expected retry count to stay 2, got %d", got)
+	}
+	if got := countHeader(p.msg.Headers, throttleCountHeader); got != 1 {
+		t.Errorf("expected throttle count 1, got %d", got)
+	}
+	if firstAttempt(amqp.Delivery{Headers: p.msg.Headers}) {
+		t.Error("expected delayed message not to count as a first attempt")
+	}
+}
+
+func TestRetryOrDeadLetterClassified(t *testing.T) {
+	tests := []struct {
+		name   string
+		cause  error
+		key    string
+		reason string
+	}{
+		{"rejected", &api.ErrRejected{StatusCode: 400, Body: "invalid number"}, "sms.dead", reasonRejected},
+		{"auth", &api.ErrAuth{StatusCode: 401}, "sms.dead", reasonAuth},
+		{"transient", &api.ErrTransient{Err: errors.New("timeout")}, "sms.retry.1", ""},
+		{"rate limited", &api.ErrRateLimited{RetryAfter: 10 * time.Second}, "sms.retry.2", ""},
+		{"circuit open", &api.ErrTransient{Err: api.ErrCircuitOpen}, "sms.retry.1", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {})
+			pub := w.publisher.(*fakePublisher)
+
+			cause := fmt.Errorf("failed to send message via API: %w", test.cause)
+			if err := w.retryOrDeadLetter(amqp.Delivery{}, cause); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			p := pub.published[0]
+			if p.key != test.key {
+				t.Errorf("expected routing key '%s', got '%s'", test.key, p.key)
+			}
+			if test.reason != "" && p.msg.Headers[deadReasonHeader] != test.reason {
+				t.Errorf("expected reason '%s', got '%v'", test.reason, p.msg.Headers[deadReasonHeader])
+			}
+		})
+	}
+}
+
+func TestFailureReason(t *testing.T) {
+	tests := []struct {
+		err    error
+		reason string
+	}{
+		{&api.ErrRejected{}, reasonRejected},
+		{&api.ErrAuth{}, reasonAuth},
+		{&api.ErrRateLimited{}, reasonRateLimited},
+		{&api.ErrTransient{Err: api.ErrCircuitOpen}, reasonCircuitOpen},
+		{&api.ErrTransient{Err: errors.New("timeout")}, reasonTransient},
+		{&segment.ErrTooManySegments{Segments: 3, Max: 2}, reasonTooLong},
+		{&templates.ErrTemplate{Name: "otp"}, reasonTemplate},
+		{&ErrInvalidMessage{Field: "type"}, reasonInvalid},
+		{&ErrUnsupportedContentType{ContentType: "text/xml"}, reasonContentType},
+		{errors.New("boom"), reasonError},
+	}
+
+	for _, test := range tests {
+		if got := failureReason(fmt.Errorf("wrapped: %w", test.err)); got != test.reason {
+			t.Errorf("expected reason '%s' for %v, got '%s'", test.reason, test.err, got)
+		}
+	}
+}
+
+func TestQueueArgs(t *testing.T) {
+	w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {})
+
+	if args := w.queueArgs(nil); args != nil {
+		t.Errorf("expected no arguments without priorities, got %v", args)
+	}
+
+	w.config.RabbitMQ.MaxPriority = 9
+	if args := w.queueArgs(nil); args["x-max-priority"] != int32(9) {
+		t.Errorf("expected x-max-priority 9, got %v", args)
+	}
+	args := w.queueArgs(amqp.Table{"x-message-ttl": int64(1000)})
+	if args["x-max-priority"] != int32(9) || args["x-message-ttl"] != int64(1000) {
+		t.Errorf("expected x-max-priority added to the arguments, got %v", args)
+	}
+}
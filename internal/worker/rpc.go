@@ -0,0 +1,163 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+
+	"github.com/starline/rabbitmq-worker/internal/api"
+	"github.com/starline/rabbitmq-worker/internal/logging"
+	"github.com/starline/rabbitmq-worker/internal/metrics"
+	"github.com/starline/rabbitmq-worker/internal/schema"
+)
+
+// Statuses of messages in replies, besides the status event ones
+const (
+	StatusDuplicate = "duplicate"
+	// StatusRetrying is a failed message that will be retried
+	StatusRetrying = "retrying"
+)
+
+// replyType is the AMQP type of replies
+const replyType = "sms.reply"
+
+// itemResult is what became of a message that was processed without error
+type itemResult struct {
+	// status is StatusSent, StatusDuplicate or StatusExpired
+	status string
+	// send is the provider's answer, also set when it rejected the message
+	send api.SendResult
+}
+
+// Reply answers a delivery that named a queue in ReplyTo
+type Reply struct {
+	// Results holds the outcome of each message of the request
+	Results []ReplyResult `json:"results"`
+	// Status, Reason and Error are set when the request as a whole failed
+	Status string `json:"status,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ReplyResult is the outcome of one message of a request
+type ReplyResult struct {
+	Index int    `json:"index"`
+	ID    string `json:"id,omitempty"`
+	// Status is sent, duplicate, expired, retrying or failed
+	Status            string  `json:"status"`
+	Provider          string  `json:"provider,omitempty"`
+	ProviderMessageID string  `json:"provider_message_id,omitempty"`
+	ProviderStatus    string  `json:"provider_status,omitempty"`
+	StatusCode        int     `json:"status_code,omitempty"`
+	Segments          int     `json:"segments,omitempty"`
+	Price             float64 `json:"price,omitempty"`
+	// Reason is the dead-letter reason of a failed or retried message
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// replyResult describes the outcome of the i-th message of a request
+func replyResult(i int, msg Message, status string, send api.SendResult) ReplyResult {
+	return ReplyResult{
+		Index:             i,
+		ID:                msg.ID,
+		Status:            status,
+		Provider:          send.Provider,
+		ProviderMessageID: send.MessageID,
+		ProviderStatus:    send.Status,
+		StatusCode:        send.StatusCode,
+		Segments:          send.Segments,
+		Price:             send.Price,
+	}
+}
+
+// errorReply describes a delivery that could not be handled as a whole
+func (w *Worker) errorReply(d amqp.Delivery, err error) Reply {
+	reply := Reply{
+		Results: []ReplyResult{},
+		Status:  StatusRetrying,
+		Reason:  failureReason(err),
+		Error:   err.Error(),
+	}
+
+	var invalid *schema.ErrInvalid
+	if errors.As(err, &invalid) {
+		reply.Status = StatusFailed
+		reply.Reason = invalid.Reason
+	} else if w.deadLettered(d, err) {
+		reply.Status = StatusFailed
+	}
+	return reply
+}
+
+// reply publishes the outcome of a delivery to its ReplyTo queue through the
+// default exchange. Only the first attempt is answered, the producer learns
+// about retries from status events. A lost reply is logged but the delivery
+// is still acknowledged.
+func (w *Worker) reply(d amqp.Delivery, reply Reply) {
+	if d.ReplyTo == "" || !firstAttempt(d) {
+		return
+	}
+
+	fields := logrus.Fields{
+		"message_id":     d.MessageId,
+		"reply_to":       d.ReplyTo,
+		"correlation_id": d.CorrelationId,
+	}
+
+	body, err := json.Marshal(reply)
+	if err != nil {
+		logging.Error("failed to encode reply", err, fields)
+		metrics.Replies.WithLabelValues("failure").Inc()
+		return
+	}
+
+	// The reply is mandatory so a reply to a queue that no longer exists is
+	// returned by RabbitMQ and counted by handleReturns. Direct reply-to
+	// only needs the requester to consume from amq.rabbitmq.reply-to, any
+	// channel can publish the reply.
+	err = w.publisher.PublishWithContext(
+		context.Background(),
+		"",        // exchange
+		d.ReplyTo, // routing key
+		true,      // mandatory
+		false,     // immediate
+		amqp.Publishing{
+			ContentType:   "application/json",
+			CorrelationId: d.CorrelationId,
+			Timestamp:     time.Now(),
+			Type:          replyType,
+			Body:          body,
+		},
+	)
+	if err != nil {
+		logging.Error("failed to publish reply", err, fields)
+		metrics.Replies.WithLabelValues("failure").Inc()
+		return
+	}
+
+	logging.Debug("reply published", fields)
+	metrics.Replies.WithLabelValues("success").Inc()
+}
+
+// handleReturns counts and logs replies RabbitMQ returned because no queue
+// took them, usually as the requester went away. These replies were already
+// counted as published. It returns when the channel is closed.
+func handleReturns(returns <-chan amqp.Return) {
+	for r := range returns {
+		if r.Type != replyType {
+			continue
+		}
+		logging.Warn("reply returned as unroutable", logrus.Fields{
+			"reply_to":       r.RoutingKey,
+			"correlation_id": r.CorrelationId,
+			"reply_code":     r.ReplyCode,
+			"reply_text":     r.ReplyText,
+		})
+		metrics.Replies.WithLabelValues("unroutable").Inc()
+	}
+}
@@ -0,0 +1,164 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/starline/rabbitmq-worker/internal/metrics"
+	"github.com/starline/rabbitmq-worker/internal/schema"
+)
+
+// published returns the messages published with a routing key
+func published(pub *fakePublisher, key string) []fakePublishing {
+	var found []fakePublishing
+	for _, p := range pub.published {
+		if p.key == key {
+			found = append(found, p)
+		}
+	}
+	return found
+}
+
+// decodeReply returns the only reply published to the reply queue
+func decodeReply(t *testing.T, pub *fakePublisher) (Reply, fakePublishing) {
+	t.Helper()
+	replies := published(pub, "reply-q")
+	if len(replies) != 1 {
+		t.Fatalf("expected one reply, got %+v", pub.published)
+	}
+	var reply Reply
+	if err := json.Unmarshal(replies[0].msg.Body, &reply); err != nil {
+		t.Fatalf("failed to decode reply: %v", err)
+	}
+	return reply, replies[0]
+}
+
+func TestReplySent(t *testing.T) {
+	w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "OK: 12345")
+	})
+
+	ack := &fakeAcknowledger{}
+	w.handleDelivery(context.Background(), amqp.Delivery{
+		Acknowledger:  ack,
+		ReplyTo:       "reply-q",
+		CorrelationId: "corr-1",
+		Body:          []byte(`{"messages":[{"id":"a1","recipient":"79218897127","body":"test"}]}`),
+	})
+
+	reply, p := decodeReply(t, w.publisher.(*fakePublisher))
+	if p.exchange != "" || p.msg.CorrelationId != "corr-1" {
+		t.Errorf("expected reply through the default exchange with correlation ID corr-1, got %q %q", p.exchange, p.msg.CorrelationId)
+	}
+	if !p.mandatory {
+		t.Error("expected reply to be published as mandatory")
+	}
+	if len(reply.Results) != 1 {
+		t.Fatalf("expected one result, got %+v", reply)
+	}
+	res := reply.Results[0]
+	if res.Status != StatusSent || res.ID != "a1" || res.ProviderMessageID != "12345" || res.StatusCode != http.StatusOK {
+		t.Errorf("unexpected result %+v", res)
+	}
+	if len(ack.acks) != 1 {
+		t.Errorf("expected delivery to be acked, got %v", ack.acks)
+	}
+}
+
+func TestReplyFailures(t *testing.T) {
+	w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	w.handleDelivery(context.Background(), amqp.Delivery{
+		Acknowledger: &fakeAcknowledger{},
+		ReplyTo:      "reply-q",
+		Body: []byte(`{"messages":[
+			{"recipient":"invalid","body":"one"},
+			{"recipient":"79210000002","body":"two"}
+		]}`),
+	})
+
+	reply, _ := decodeReply(t, w.publisher.(*fakePublisher))
+	if len(reply.Results) != 2 {
+		t.Fatalf("expected two results, got %+v", reply)
+	}
+	if res := reply.Results[0]; res.Status != StatusFailed || res.Reason != reasonRecipient || res.Error == "" {
+		t.Errorf("expected invalid recipient to fail, got %+v", res)
+	}
+	if res := reply.Results[1]; res.Index != 1 || res.Status != StatusRetrying || res.Reason != reasonTransient ||
+		res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected unavailable provider to be retried, got %+v", res)
+	}
+}
+
+func TestReplyInvalidPayload(t *testing.T) {
+	w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {})
+
+	w.handleDelivery(context.Background(), amqp.Delivery{
+		Acknowledger: &fakeAcknowledger{},
+		ReplyTo:      "reply-q",
+		Body:         []byte(`{"messages":[]}`),
+	})
+
+	reply, _ := decodeReply(t, w.publisher.(*fakePublisher))
+	if reply.Status != StatusFailed || reply.Reason != schema.ReasonSchema || reply.Error == "" {
+		t.Errorf("expected failed reply for invalid payload, got %+v", reply)
+	}
+}
+
+// counterValue returns the current value of a counter
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatalf("failed to read counter: %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestHandleReturns(t *testing.T) {
+	unroutable := metrics.Replies.WithLabelValues("unroutable")
+	before := counterValue(t, unroutable)
+
+	returns := make(chan amqp.Return, 2)
+	returns <- amqp.Return{Type: replyType, RoutingKey: "gone-q", CorrelationId: "corr-1", ReplyCode: amqp.NoRoute}
+	returns <- amqp.Return{Type: "other", RoutingKey: "sms.retry.1"}
+	close(returns)
+	handleReturns(returns)
+
+	if got := counterValue(t, unroutable) - before; got != 1 {
+		t.Errorf("expected one unroutable reply, got %v", got)
+	}
+}
+
+func TestReplyNotRequested(t *testing.T) {
+	w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {})
+	pub := w.publisher.(*fakePublisher)
+
+	w.handleDelivery(context.Background(), amqp.Delivery{
+		Acknowledger: &fakeAcknowledger{},
+		Body:         []byte(`{"messages":[{"recipient":"79218897127","body":"test"}]}`),
+	})
+	if len(pub.published) != 0 {
+		t.Errorf("expected no reply without ReplyTo, got %+v", pub.published)
+	}
+
+	// Retries were answered on the first attempt
+	w.handleDelivery(context.Background(), amqp.Delivery{
+		Acknowledger: &fakeAcknowledger{},
+		ReplyTo:      "reply-q",
+		Headers:      amqp.Table{retryCountHeader: int32(1)},
+		Body:         []byte(`{"messages":[{"recipient":"79218897127","body":"test"}]}`),
+	})
+	if replies := published(pub, "reply-q"); len(replies) != 0 {
+		t.Errorf("expected no reply to a retry, got %+v", replies)
+	}
+}
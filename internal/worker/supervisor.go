@@ -0,0 +1,80 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/starline/rabbitmq-worker/internal/logging"
+	"github.com/starline/rabbitmq-worker/internal/metrics"
+)
+
+// connectWithBackoff connects to RabbitMQ, retrying with jittered exponential
+// backoff until it succeeds, the context is cancelled or the configured number
+// of attempts is used up
+func (w *Worker) connectWithBackoff(ctx context.Context) error {
+	cfg := w.config.RabbitMQ.Reconnect
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 || w.connectedOnce {
+			metrics.RabbitMQReconnectAttempts.Inc()
+		}
+
+		err := w.connect()
+		if err == nil {
+			w.connectedOnce = true
+			metrics.RabbitMQLastConnected.SetToCurrentTime()
+			return nil
+		}
+		w.closeConnection()
+
+		if cfg.MaxAttempts > 0 && attempt+1 >= cfg.MaxAttempts {
+			return fmt.Errorf("failed to connect to RabbitMQ after %d attempts: %w", attempt+1, err)
+		}
+
+		delay := backoffDelay(cfg.InitialDelay, cfg.MaxDelay, attempt)
+		logging.Warn("retrying RabbitMQ connection", logrus.Fields{
+			"attempt": attempt + 1,
+			"delay":   delay.String(),
+			"error":   err.Error(),
+		})
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// closeConnection closes the current channel and connection, ignoring errors
+// from ones that are already closed
+func (w *Worker) closeConnection() {
+	w.closeEventsChannel()
+	if w.channel != nil {
+		w.channel.Close()
+		w.channel = nil
+	}
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+}
+
+// backoffDelay returns the wait before the next connection attempt. The delay
+// doubles with every attempt up to max, and a random half of it is jitter so
+// that many workers don't reconnect at the same moment.
+func backoffDelay(initial, max time.Duration, attempt int) time.Duration {
+	delay := max
+	if attempt < 32 {
+		if d := initial << attempt; d > 0 && d < max {
+			delay = d
+		}
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
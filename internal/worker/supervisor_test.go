@@ -0,0 +1,84 @@
+package worker
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/starline/rabbitmq-worker/internal/config"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	tests := []struct {
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{0, 500 * time.Millisecond, time.Second},
+		{1, time.Second, 2 * time.Second},
+		{3, 4 * time.Second, 8 * time.Second},
+		{10, 5 * time.Second, 10 * time.Second}, // capped
+		{100, 5 * time.Second, 10 * time.Second},
+	}
+
+	for _, test := range tests {
+		for i := 0; i < 20; i++ {
+			got := backoffDelay(time.Second, 10*time.Second, test.attempt)
+			if got < test.min || got > test.max {
+				t.Errorf("attempt %d: expected delay in [%s, %s], got %s", test.attempt, test.min, test.max, got)
+			}
+		}
+	}
+}
+
+// unusedPort returns a local port nothing is listening on
+func unusedPort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	return port
+}
+
+func TestConnectWithBackoffGivesUp(t *testing.T) {
+	cfg := &config.Config{
+		RabbitMQ: config.RabbitMQConfig{
+			Host: "127.0.0.1",
+			Port: unusedPort(t),
+			Reconnect: config.ReconnectConfig{
+				InitialDelay: time.Millisecond,
+				MaxDelay:     time.Millisecond,
+				MaxAttempts:  3,
+			},
+		},
+	}
+	w := New(cfg, nil)
+
+	if err := w.connectWithBackoff(context.Background()); err == nil {
+		t.Fatal("expected error after attempts are exhausted")
+	}
+}
+
+func TestConnectWithBackoffCancelled(t *testing.T) {
+	cfg := &config.Config{
+		RabbitMQ: config.RabbitMQConfig{
+			Host: "127.0.0.1",
+			Port: unusedPort(t),
+			Reconnect: config.ReconnectConfig{
+				InitialDelay: time.Hour,
+				MaxDelay:     time.Hour,
+			},
+		},
+	}
+	w := New(cfg, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := w.connectWithBackoff(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
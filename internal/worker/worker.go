@@ -2,8 +2,10 @@ package worker
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
@@ -12,41 +14,118 @@ import (
 	
 	"github.com/starline/rabbitmq-worker/internal/api"
 	"github.com/starline/rabbitmq-worker/internal/config"
+	"github.com/starline/rabbitmq-worker/internal/dlr"
 	"github.com/starline/rabbitmq-worker/internal/logging"
 	"github.com/starline/rabbitmq-worker/internal/metrics"
+	"github.com/starline/rabbitmq-worker/internal/phone"
+	"github.com/starline/rabbitmq-worker/internal/ratelimit"
+	"github.com/starline/rabbitmq-worker/internal/schema"
+	"github.com/starline/rabbitmq-worker/internal/segment"
+	"github.com/starline/rabbitmq-worker/internal/templates"
+	"github.com/starline/rabbitmq-worker/internal/translit"
 )
 
 // Worker represents the main worker that processes RabbitMQ messages
 type Worker struct {
 	config    *config.Config
-	apiClient *api.Client
-	conn      *amqp.Connection
-	channel   *amqp.Channel
+	provider  api.Provider
+	conn      connection
+	channel   consumeChannel
+	publisher publisher
+	dedup     DedupStore
+	limiter   *ratelimit.Limiter
+	templates *templates.Store
+	codecs    map[string]Codec
+	reports   *dlr.Tracker
+
+	// eventsChannel publishes status events in confirm mode, apart from
+	// channel so that waiting for confirms doesn't hold up retries. It and
+	// events are guarded by eventsMu.
+	eventsChannel *amqp.Channel
+	eventsMu      sync.RWMutex
+	events        publisher
+
+	// consumerTag identifies the consumer so it can be cancelled on shutdown
+	consumerTag string
+	// inFlight is the number of deliveries being processed
+	inFlight atomic.Int64
+	// connectedOnce is set after the first successful connection
+	connectedOnce bool
+}
+
+// Option customizes a worker created by New
+type Option func(*Worker)
+
+// WithDedupStore sets the store used to skip messages that were already sent.
+// By default an in-memory store configured from cfg.Dedup is used.
+func WithDedupStore(store DedupStore) Option {
+	return func(w *Worker) {
+		w.dedup = store
+	}
+}
+
+// WithTemplates sets the store message templates are rendered from. By
+// default the worker has no templates.
+func WithTemplates(store *templates.Store) Option {
+	return func(w *Worker) {
+		w.templates = store
+	}
+}
+
+// WithDeliveryTracker sets the tracker sent messages are registered with to
+// match their delivery reports. By default a tracker configured from cfg.DLR
+// is used.
+func WithDeliveryTracker(tracker *dlr.Tracker) Option {
+	return func(w *Worker) {
+		w.reports = tracker
+	}
 }
 
 // New creates a new worker instance
-func New(cfg *config.Config, apiClient *api.Client) *Worker {
-	return &Worker{
-		config:    cfg,
-		apiClient: apiClient,
+func New(cfg *config.Config, provider api.Provider, opts ...Option) *Worker {
+	w := &Worker{
+		config:      cfg,
+		provider:    provider,
+		dedup:       NewMemoryDedupStore(cfg.Dedup.Window, cfg.Dedup.MaxEntries),
+		limiter:     ratelimit.New(&cfg.RateLimit),
+		templates:   templates.New(&cfg.Templates),
+		codecs:      defaultCodecs(schema.MustNew()),
+		reports:     dlr.NewTracker(cfg.DLR.Window, cfg.DLR.MaxEntries),
+		consumerTag: fmt.Sprintf("%s-worker-%d", cfg.RabbitMQ.Queue, os.Getpid()),
+	}
+	for _, opt := range opts {
+		opt(w)
 	}
+	w.reports.OnUpdate(w.publishDeliveryReport)
+	return w
 }
 
-// Start initializes connection to RabbitMQ and starts consuming messages
+// Start connects to RabbitMQ and consumes messages until the context is
+// cancelled, reconnecting whenever the connection or channel is lost
 func (w *Worker) Start(ctx context.Context) error {
-	if err := w.connect(); err != nil {
-		return fmt.Errorf("failed to connect to RabbitMQ: %w", err)
-	}
+	for {
+		if err := w.connectWithBackoff(ctx); err != nil {
+			return err
+		}
 
-	logging.Info("worker started successfully", logrus.Fields{
-		"queue":    w.config.RabbitMQ.Queue,
-		"host":     w.config.RabbitMQ.Host,
-		"port":     w.config.RabbitMQ.Port,
-	})
+		logging.Info("worker started successfully", logrus.Fields{
+			"queue":    w.config.RabbitMQ.Queue,
+			"host":     w.config.RabbitMQ.Host,
+			"port":     w.config.RabbitMQ.Port,
+		})
 
-	metrics.WorkerHealthy.Set(1)
+		metrics.WorkerHealthy.Set(1)
 
-	return w.consume(ctx)
+		err := w.consume(ctx)
+		if ctx.Err() != nil {
+			logging.Info("worker context cancelled, shutting down")
+			return ctx.Err()
+		}
+
+		logging.Error("lost connection to RabbitMQ, reconnecting", err)
+		metrics.WorkerHealthy.Set(0)
+		w.closeConnection()
+	}
 }
 
 // connect establishes connection to RabbitMQ
@@ -69,6 +148,17 @@ func (w *Worker) connect() error {
 		return err
 	}
 	w.channel = ch
+	w.publisher = ch
+	go handleReturns(ch.NotifyReturn(make(chan amqp.Return, 1)))
+
+	// Limit unacknowledged deliveries so they are spread across consumers
+	if err := ch.Qos(w.config.RabbitMQ.Prefetch, 0, false); err != nil {
+		logging.Error("failed to set channel QoS", err, logrus.Fields{
+			"prefetch": w.config.RabbitMQ.Prefetch,
+		})
+		metrics.WorkerHealthy.Set(0)
+		return err
+	}
 
 	// Declare queue (create if not exists)
 	_, err = ch.QueueDeclare(
@@ -77,7 +167,7 @@ func (w *Worker) connect() error {
 		false,                   // delete when unused
 		false,                   // exclusive
 		false,                   // no-wait
-		nil,                     // arguments
+		w.queueArgs(nil),        // arguments
 	)
 	if err != nil {
 		logging.Error("failed to declare queue", err, logrus.Fields{
@@ -87,6 +177,22 @@ func (w *Worker) connect() error {
 		return err
 	}
 
+	if err := w.declareRetryTopology(ch); err != nil {
+		logging.Error("failed to declare retry topology", err, logrus.Fields{
+			"exchange": w.config.RabbitMQ.Retry.Exchange,
+		})
+		metrics.WorkerHealthy.Set(0)
+		return err
+	}
+
+	if err := w.openEventsChannel(conn); err != nil {
+		logging.Error("failed to open status events channel", err, logrus.Fields{
+			"exchange": w.config.RabbitMQ.Events.Exchange,
+		})
+		metrics.WorkerHealthy.Set(0)
+		return err
+	}
+
 	logging.Info("successfully connected to RabbitMQ", logrus.Fields{
 		"queue": w.config.RabbitMQ.Queue,
 	})
@@ -94,11 +200,31 @@ func (w *Worker) connect() error {
 	return nil
 }
 
-// consume starts consuming messages from RabbitMQ queue
+// connection is the part of *amqp.Connection the consume loop uses
+type connection interface {
+	NotifyClose(receiver chan *amqp.Error) chan *amqp.Error
+	Close() error
+}
+
+// consumeChannel is the part of *amqp.Channel the consume loop uses
+type consumeChannel interface {
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Cancel(consumer string, noWait bool) error
+	NotifyClose(receiver chan *amqp.Error) chan *amqp.Error
+	Close() error
+}
+
+// consume registers the consumer and handles deliveries until the context is
+// cancelled or the connection to RabbitMQ is lost
 func (w *Worker) consume(ctx context.Context) error {
+	connClosed := w.conn.NotifyClose(make(chan *amqp.Error, 1))
+	chClosed := w.channel.NotifyClose(make(chan *amqp.Error, 1))
+	// A nil channel never fires when status events are disabled
+	eventsClosed := w.eventsClosed()
+
 	msgs, err := w.channel.Consume(
 		w.config.RabbitMQ.Queue, // queue
-		"",                      // consumer
+		w.consumerTag,           // consumer
 		false,                   // auto-ack (manual ack for reliability)
 		false,                   // exclusive
 		false,                   // no-local
@@ -112,41 +238,176 @@ func (w *Worker) consume(ctx context.Context) error {
 	}
 
 	logging.Info("starting message consumption", logrus.Fields{
-		"queue": w.config.RabbitMQ.Queue,
+		"queue":       w.config.RabbitMQ.Queue,
+		"concurrency": w.config.RabbitMQ.Concurrency,
+		"prefetch":    w.config.RabbitMQ.Prefetch,
+	})
+
+	// Deliveries are processed under their own context so that a shutdown
+	// lets in-flight sends finish instead of aborting them
+	processCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < w.config.RabbitMQ.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.runConsumer(processCtx, msgs)
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-ctx.Done():
+		w.drain(done, cancel)
+		return ctx.Err()
+	case amqpErr := <-connClosed:
+		err = fmt.Errorf("connection closed: %v", amqpErr)
+	case amqpErr := <-chClosed:
+		err = fmt.Errorf("channel closed: %v", amqpErr)
+	case amqpErr := <-eventsClosed:
+		err = fmt.Errorf("status events channel closed: %v", amqpErr)
+	case <-done:
+		err = fmt.Errorf("delivery channel closed")
+	}
+
+	// Deliveries can't be acknowledged on a closed channel, RabbitMQ will
+	// redeliver them, so abort in-flight sends right away
+	cancel()
+	<-done
+
+	return err
+}
+
+// drain stops receiving new deliveries and waits for in-flight ones to be
+// acknowledged. Sends still running after the shutdown timeout are aborted
+// and their messages retried.
+func (w *Worker) drain(done <-chan struct{}, abort context.CancelFunc) {
+	logging.Info("draining in-flight messages", logrus.Fields{
+		"in_flight": w.inFlight.Load(),
+		"timeout":   w.config.RabbitMQ.ShutdownTimeout.String(),
 	})
 
+	// Cancelling the consumer closes the delivery channel once the
+	// deliveries already received are handed out
+	if err := w.channel.Cancel(w.consumerTag, false); err != nil {
+		logging.Error("failed to cancel consumer", err, logrus.Fields{
+			"consumer": w.consumerTag,
+		})
+		abort()
+	}
+
+	timer := time.NewTimer(w.config.RabbitMQ.ShutdownTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		logging.Info("all in-flight messages drained")
+		return
+	case <-timer.C:
+		logging.Warn("shutdown timeout reached, aborting in-flight messages", logrus.Fields{
+			"in_flight": w.inFlight.Load(),
+		})
+	}
+
+	abort()
+	<-done
+}
+
+// runConsumer handles deliveries until the context is cancelled or msgs is closed
+func (w *Worker) runConsumer(ctx context.Context, msgs <-chan amqp.Delivery) {
 	for {
 		select {
 		case <-ctx.Done():
-			logging.Info("worker context cancelled, shutting down")
-			return ctx.Err()
+			return
 		case d, ok := <-msgs:
 			if !ok {
-				logging.Warn("message channel closed, attempting to reconnect")
-				metrics.WorkerHealthy.Set(0)
-				if err := w.reconnect(); err != nil {
-					return err
-				}
-				return w.consume(ctx) // Restart consumption
-			}
-			
-			if err := w.processMessage(d); err != nil {
-				logging.Error("failed to process message", err, logrus.Fields{
-					"message_id": d.MessageId,
-					"body":       string(d.Body),
-				})
-				// Reject message and don't requeue to prevent infinite loops
-				d.Nack(false, false)
-			} else {
-				// Acknowledge successful processing
-				d.Ack(false)
+				return
 			}
+
+			w.inFlight.Add(1)
+			metrics.MessagesInFlight.Inc()
+
+			w.handleDelivery(ctx, d)
+
+			w.inFlight.Add(-1)
+			metrics.MessagesInFlight.Dec()
+		}
+	}
+}
+
+// handleDelivery processes a delivery and acknowledges it
+func (w *Worker) handleDelivery(ctx context.Context, d amqp.Delivery) {
+	if deadline := w.config.API.MessageDeadline; deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, deadline)
+		defer cancel()
+	}
+
+	reply, failed, err := w.processMessage(ctx, d)
+	if err != nil {
+		logging.Error("failed to process message", err, logrus.Fields{
+			"message_id": d.MessageId,
+			"body":       string(d.Body),
+		})
+		reply = w.errorReply(d, err)
+		if err := w.retryOrDeadLetter(d, err); err != nil {
+			w.requeue(d, err)
+			return
+		}
+		if reply.Status == StatusFailed {
+			w.publishEvent(StatusEvent{
+				Status:    StatusFailed,
+				MessageID: d.MessageId,
+				Reason:    reply.Reason,
+				Error:     reply.Error,
+			}, d.CorrelationId)
+		}
+	}
+
+	// Only the failed items of a request are retried, the sent ones are done
+	for _, f := range failed {
+		if err := w.retryOrDeadLetter(f.delivery, f.err); err != nil {
+			// Requeueing the whole request resends the items that succeeded,
+			// which is still better than losing the failed ones
+			w.requeue(d, err)
+			return
 		}
 	}
+
+	w.reply(d, reply)
+
+	// Acknowledge processed or republished message
+	if err := d.Ack(false); err != nil {
+		logging.Error("failed to ack message", err, logrus.Fields{
+			"message_id": d.MessageId,
+		})
+	}
+}
+
+// requeue returns a delivery to its queue after it could not be republished
+func (w *Worker) requeue(d amqp.Delivery, err error) {
+	logging.Error("failed to republish message, requeueing", err, logrus.Fields{
+		"message_id": d.MessageId,
+	})
+	// Leave the message in the queue rather than lose it
+	if err := d.Nack(false, true); err != nil {
+		logging.Error("failed to nack message", err, logrus.Fields{
+			"message_id": d.MessageId,
+		})
+	}
 }
 
-// processMessage processes a single message from RabbitMQ
-func (w *Worker) processMessage(delivery amqp.Delivery) error {
+// processMessage processes a single message from RabbitMQ. It returns an
+// error if the delivery as a whole cannot be handled, otherwise the reply
+// describing each item and the items of the request that failed to send.
+func (w *Worker) processMessage(ctx context.Context, delivery amqp.Delivery) (Reply, []failedMessage, error) {
 	timer := prometheus.NewTimer(metrics.MessageProcessingDuration)
 	defer timer.ObserveDuration()
 
@@ -158,44 +419,218 @@ func (w *Worker) processMessage(delivery amqp.Delivery) error {
 		"body_length": len(delivery.Body),
 	})
 
-	// Parse JSON message
-	var msgReq MessageRequest
-	if err := json.Unmarshal(delivery.Body, &msgReq); err != nil {
-		return fmt.Errorf("failed to unmarshal message: %w", err)
+	msgReq, err := w.decode(delivery)
+	if err != nil {
+		return Reply{}, nil, err
 	}
 
 	// Process each message in the request
-	for _, msg := range msgReq.Messages {
-		if err := w.apiClient.SendMessage(msg.Recipient, msg.Body); err != nil {
-			return fmt.Errorf("failed to send message via API: %w", err)
+	reply := Reply{Results: make([]ReplyResult, 0, len(msgReq.Messages))}
+	var failed []failedMessage
+	for i, msg := range msgReq.Messages {
+		result, sendErr := w.processItem(ctx, delivery, i, msg)
+		if sendErr == nil {
+			reply.Results = append(reply.Results, replyResult(i, msg, result.status, result.send))
+			continue
 		}
-		
-		logging.Info("message sent successfully", logrus.Fields{
-			"recipient": msg.Recipient,
-			"body":      msg.Body,
-		})
+
+		status := StatusRetrying
+		if w.deadLettered(delivery, sendErr) {
+			status = StatusFailed
+			w.publishStatus(delivery, msg, StatusEvent{
+				Status: StatusFailed,
+				Reason: failureReason(sendErr),
+				Error:  sendErr.Error(),
+			})
+		}
+		res := replyResult(i, msg, status, result.send)
+		res.Reason = failureReason(sendErr)
+		res.Error = sendErr.Error()
+		reply.Results = append(reply.Results, res)
+
+		item, err := itemDelivery(delivery, i, msg, len(msgReq.Messages))
+		if err != nil {
+			return Reply{}, nil, err
+		}
+		failed = append(failed, failedMessage{delivery: item, err: sendErr})
 	}
 
 	metrics.MessagesProcessed.Inc()
-	return nil
+	return reply, failed, nil
 }
 
-// reconnect attempts to reconnect to RabbitMQ
-func (w *Worker) reconnect() error {
-	logging.Info("attempting to reconnect to RabbitMQ")
-	
-	// Close existing connections
-	if w.channel != nil {
-		w.channel.Close()
+// processItem sends the i-th message of a delivery unless it was already sent.
+// An error means the message has to be retried or dead-lettered.
+func (w *Worker) processItem(ctx context.Context, delivery amqp.Delivery, i int, msg Message) (itemResult, error) {
+	fields := logrus.Fields{
+		"message_id": delivery.MessageId,
+		"index":      i,
+		"recipient":  msg.Recipient,
 	}
-	if w.conn != nil {
-		w.conn.Close()
+	if msg.ID != "" {
+		fields["id"] = msg.ID
+	}
+	if msg.Type != "" {
+		fields["type"] = msg.Type
+	}
+	if len(msg.Tags) > 0 {
+		fields["tags"] = msg.Tags
 	}
 
-	// Wait a bit before reconnecting
-	time.Sleep(5 * time.Second)
+	if err := msg.Validate(); err != nil {
+		fields["error"] = err.Error()
+		logging.Warn("invalid message", fields)
+		metrics.MessageOutcomes.WithLabelValues(metrics.OutcomeInvalid).Inc()
+		return itemResult{}, err
+	}
 
-	return w.connect()
+	if expiry := w.expiry(delivery, msg); !expiry.IsZero() && time.Now().After(expiry) {
+		fields["expires_at"] = expiry
+		if !delivery.Timestamp.IsZero() {
+			fields["age"] = time.Since(delivery.Timestamp).String()
+		}
+		logging.Warn("dropping expired message", fields)
+		metrics.MessageOutcomes.WithLabelValues(metrics.OutcomeExpired).Inc()
+		w.publishStatus(delivery, msg, StatusEvent{Status: StatusExpired})
+		return itemResult{status: StatusExpired}, nil
+	}
+
+	number, err := phone.Normalize(msg.Recipient, w.config.Phone.DefaultCountry)
+	if err != nil {
+		fields["error"] = err.Error()
+		logging.Warn("invalid recipient", fields)
+		metrics.MessageOutcomes.WithLabelValues(metrics.OutcomeInvalid).Inc()
+		return itemResult{}, err
+	}
+	msg.Recipient = number.E164
+	fields["recipient"] = number.E164
+	fields["country"] = number.Country
+
+	if msg.Body == "" && msg.Template != "" {
+		body, err := w.templates.Render(msg.Template, msg.Locale, msg.Params)
+		if err != nil {
+			fields["template"] = msg.Template
+			fields["error"] = err.Error()
+			logging.Warn("failed to render message template", fields)
+			metrics.MessageOutcomes.WithLabelValues(metrics.OutcomeInvalid).Inc()
+			return itemResult{}, err
+		}
+		msg.Body = body
+	}
+
+	if body, ok := translit.Apply(msg.Body, w.transliteration(delivery, msg)); ok {
+		msg.Body = body
+		fields["transliterated"] = true
+	}
+
+	info, err := segment.Check(msg.Body, w.config.SMS.MaxSegments)
+	fields["encoding"] = info.Encoding
+	fields["segments"] = info.Segments
+	if err != nil {
+		fields["error"] = err.Error()
+		logging.Warn("message too long", fields)
+		metrics.MessageOutcomes.WithLabelValues(metrics.OutcomeTooLong).Inc()
+		return itemResult{}, err
+	}
+
+	key := dedupKey(delivery, i, msg)
+	seen, err := w.dedup.Seen(key)
+	if err != nil {
+		// Sending twice is better than not sending at all
+		logging.Error("failed to check dedup store", err, fields)
+	}
+	if seen {
+		logging.Info("skipping already sent message", fields)
+		metrics.MessageOutcomes.WithLabelValues(metrics.OutcomeDuplicate).Inc()
+		return itemResult{status: StatusDuplicate}, nil
+	}
+
+	// Retries of a message were accepted on the first attempt
+	if firstAttempt(delivery) {
+		w.publishStatus(delivery, msg, StatusEvent{Status: StatusAccepted})
+	}
+
+	reservation, err := w.limiter.Acquire(ctx, msg.Recipient)
+	if err != nil {
+		fields["error"] = err.Error()
+		logging.Warn("message throttled by rate limit", fields)
+		metrics.MessageOutcomes.WithLabelValues(metrics.OutcomeThrottled).Inc()
+		return itemResult{}, err
+	}
+
+	result, err := w.provider.Send(ctx, api.Message{
+		ID:          msg.ID,
+		Recipient:   msg.Recipient,
+		Body:        msg.Body,
+		Source:      msg.SenderName(),
+		Type:        msg.Type,
+		Priority:    msg.Priority,
+		CallbackURL: msg.CallbackURL,
+	})
+	fields["provider"] = result.Provider
+	if err != nil {
+		// Only sent messages count against the recipient's quota
+		reservation.Cancel()
+		logging.Error("failed to send message via API", err, fields)
+		metrics.MessageOutcomes.WithLabelValues(metrics.OutcomeFailed).Inc()
+		return itemResult{send: result}, fmt.Errorf("failed to send message via API: %w", err)
+	}
+
+	logging.Info("message sent successfully", logrus.Fields{
+		"recipient":           msg.Recipient,
+		"country":             number.Country,
+		"body":                msg.Body,
+		"provider":            result.Provider,
+		"encoding":            info.Encoding,
+		"segments":            info.Segments,
+		"provider_message_id": result.MessageID,
+		"provider_status":     result.Status,
+		"provider_segments":   result.Segments,
+		"price":               result.Price,
+	})
+	metrics.MessageOutcomes.WithLabelValues(metrics.OutcomeSent).Inc()
+	metrics.MessageSegments.WithLabelValues(info.Encoding).Observe(float64(info.Segments))
+
+	err = w.reports.Track(dlr.Record{
+		ProviderMessageID: result.MessageID,
+		Provider:          result.Provider,
+		MessageID:         delivery.MessageId,
+		CorrelationID:     delivery.CorrelationId,
+		ID:                msg.ID,
+		Type:              msg.Type,
+		Recipient:         msg.Recipient,
+	})
+	if err != nil {
+		// The message is sent, only its delivery report won't be matched
+		logging.Error("failed to track message for delivery reports", err, fields)
+	}
+	w.publishStatus(delivery, msg, StatusEvent{
+		Status:            StatusSent,
+		Provider:          result.Provider,
+		ProviderMessageID: result.MessageID,
+	})
+
+	if err := w.dedup.MarkCompleted(key); err != nil {
+		logging.Error("failed to mark message as sent", err, fields)
+	}
+	return itemResult{status: StatusSent, send: result}, nil
+}
+
+// transliteration returns the transliteration policy for a message. The
+// message's own policy wins over the one configured for its publisher, which
+// wins over the default. One-time codes are not transliterated unless the
+// message asks for it.
+func (w *Worker) transliteration(delivery amqp.Delivery, msg Message) string {
+	if msg.Transliterate != "" {
+		return msg.Transliterate
+	}
+	if msg.Type == TypeOTP {
+		return translit.PolicyNever
+	}
+	if policy, ok := w.config.SMS.TransliterateSources[delivery.AppId]; ok && delivery.AppId != "" {
+		return policy
+	}
+	return w.config.SMS.Transliterate
 }
 
 // Stop gracefully shuts down the worker
@@ -204,6 +639,8 @@ func (w *Worker) Stop() error {
 	
 	metrics.WorkerHealthy.Set(0)
 	
+	w.closeEventsChannel()
+
 	if w.channel != nil {
 		if err := w.channel.Close(); err != nil {
 			logging.Error("failed to close channel", err)
@@ -1,16 +1,32 @@
 package worker
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
 
 	"github.com/starline/rabbitmq-worker/internal/api"
 	"github.com/starline/rabbitmq-worker/internal/config"
+	"github.com/starline/rabbitmq-worker/internal/dlr"
+	"github.com/starline/rabbitmq-worker/internal/ratelimit"
+	"github.com/starline/rabbitmq-worker/internal/templates"
 )
 
 func TestNew(t *testing.T) {
 	cfg := &config.Config{}
-	apiClient := api.NewClient(&config.APIConfig{})
+	apiClient, err := api.NewClient(&config.APIConfig{})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
 	
 	worker := New(cfg, apiClient)
 	
@@ -22,8 +38,8 @@ func TestNew(t *testing.T) {
 		t.Error("expected config to be set")
 	}
 	
-	if worker.apiClient != apiClient {
-		t.Error("expected API client to be set")
+	if worker.provider != apiClient {
+		t.Error("expected provider to be set")
 	}
 }
 
@@ -58,4 +74,796 @@ func TestMessageUnmarshal(t *testing.T) {
 	if msg.Body != expectedBody {
 		t.Errorf("expected body '%s', got '%s'", expectedBody, msg.Body)
 	}
-}
\ No newline at end of file
+}
+
+func TestMessageValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		msg   Message
+		field string
+	}{
+		{"minimal", Message{Recipient: "79218897127", Body: "test"}, ""},
+		{"full", Message{
+			ID: "42", Type: TypeOTP, Transliterate: "always", Priority: 9, TTL: 300, Source: "StarLine", Sender: "StarLine",
+			CallbackURL: "https://example.com/dlr", Tags: []string{"auth"},
+		}, ""},
+		{"unknown type", Message{Type: "promo"}, "type"},
+		{"unknown transliteration", Message{Transliterate: "alway"}, "transliterate"},
+		{"priority too high", Message{Priority: 10}, "priority"},
+		{"negative ttl", Message{TTL: -1}, "ttl"},
+		{"conflicting sender", Message{Source: "StarLine", Sender: "Other"}, "sender"},
+		{"relative callback", Message{CallbackURL: "/dlr"}, "callback_url"},
+		{"ftp callback", Message{CallbackURL: "ftp://example.com/dlr"}, "callback_url"},
+		{"empty tag", Message{Tags: []string{""}}, "tags"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.msg.Validate()
+			var invalid *ErrInvalidMessage
+			switch {
+			case test.field == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case test.field != "" && (!errors.As(err, &invalid) || invalid.Field != test.field):
+				t.Errorf("expected invalid field %s, got %v", test.field, err)
+			}
+		})
+	}
+}
+
+func TestMessageExpiry(t *testing.T) {
+	sent := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	later := sent.Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		msg      Message
+		expected time.Time
+	}{
+		{"no expiry", Message{}, time.Time{}},
+		{"ttl", Message{TTL: 60}, sent.Add(time.Minute)},
+		{"expires_at", Message{ExpiresAt: &later}, later},
+		{"earliest wins", Message{TTL: 7200, ExpiresAt: &later}, later},
+	}
+
+	for _, test := range tests {
+		if got := test.msg.Expiry(sent); !got.Equal(test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestMessageUnmarshalExtended(t *testing.T) {
+	var msgReq MessageRequest
+	err := json.Unmarshal([]byte(`{"messages":[{
+		"id": "42", "recipient": "79218897127", "body": "test", "type": "otp", "priority": 5,
+		"expires_at": "2024-05-01T12:00:00Z", "ttl": 300, "sender": "StarLine",
+		"callback_url": "https://example.com/dlr", "tags": ["auth", "mobile"]
+	}]}`), &msgReq)
+	if err != nil {
+		t.Fatalf("failed to unmarshal JSON: %v", err)
+	}
+
+	msg := msgReq.Messages[0]
+	if msg.ID != "42" || msg.Type != TypeOTP || msg.Priority != 5 || msg.TTL != 300 {
+		t.Errorf("unexpected message %+v", msg)
+	}
+	if msg.ExpiresAt == nil || !msg.ExpiresAt.Equal(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected expires_at %v", msg.ExpiresAt)
+	}
+	if msg.SenderName() != "StarLine" || len(msg.Tags) != 2 {
+		t.Errorf("unexpected sender %q or tags %v", msg.SenderName(), msg.Tags)
+	}
+}
+
+// fakeAcknowledger records acks and nacks issued for deliveries
+type fakeAcknowledger struct {
+	mu    sync.Mutex
+	acks  []uint64
+	nacks []uint64
+}
+
+func (f *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.acks = append(f.acks, tag)
+	return nil
+}
+
+func (f *fakeAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nacks = append(f.nacks, tag)
+	return nil
+}
+
+func (f *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return f.Nack(tag, false, requeue)
+}
+
+// fakePublisher records messages published by the worker
+type fakePublisher struct {
+	mu        sync.Mutex
+	published []fakePublishing
+	err       error
+}
+
+type fakePublishing struct {
+	exchange  string
+	key       string
+	mandatory bool
+	msg       amqp.Publishing
+}
+
+func (f *fakePublisher) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	f.published = append(f.published, fakePublishing{exchange: exchange, key: key, mandatory: mandatory, msg: msg})
+	return nil
+}
+
+func newTestWorker(t *testing.T, handler http.HandlerFunc) *Worker {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	cfg := &config.Config{
+		RabbitMQ: config.RabbitMQConfig{
+			Queue:       "sms",
+			Concurrency: 4,
+			Retry: config.RetryConfig{
+				MaxAttempts:  3,
+				Delays:       []time.Duration{time.Second, time.Minute},
+				Exchange:     "sms.dlx",
+				DeadQueue:    "sms.dead",
+				InvalidQueue: "sms.invalid",
+			},
+		},
+		API:   config.APIConfig{URL: server.URL},
+		Phone: config.PhoneConfig{DefaultCountry: "RU"},
+	}
+	client, err := api.NewClient(&cfg.API)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	w := New(cfg, client)
+	w.publisher = &fakePublisher{}
+	return w
+}
+
+func TestHandleDelivery(t *testing.T) {
+	w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	ack := &fakeAcknowledger{}
+	w.handleDelivery(context.Background(), amqp.Delivery{
+		Acknowledger: ack,
+		DeliveryTag:  1,
+		Body:         []byte(`{"messages":[{"recipient":"79218897127","body":"test"}]}`),
+	})
+	w.handleDelivery(context.Background(), amqp.Delivery{
+		Acknowledger: ack,
+		DeliveryTag:  2,
+		Body:         []byte(`not json`),
+	})
+
+	if len(ack.acks) != 2 {
+		t.Errorf("expected both deliveries to be acked, got %v", ack.acks)
+	}
+
+	pub := w.publisher.(*fakePublisher)
+	if len(pub.published) != 1 || pub.published[0].key != "sms.invalid" {
+		t.Errorf("expected invalid delivery to be sent to the invalid queue, got %+v", pub.published)
+	}
+}
+
+func TestHandleDeliveryRepublishFailure(t *testing.T) {
+	w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	w.publisher = &fakePublisher{err: errors.New("channel closed")}
+
+	ack := &fakeAcknowledger{}
+	w.handleDelivery(context.Background(), amqp.Delivery{
+		Acknowledger: ack,
+		DeliveryTag:  1,
+		Body:         []byte(`not json`),
+	})
+
+	if len(ack.nacks) != 1 {
+		t.Errorf("expected delivery to be nacked for requeue, got %v", ack.nacks)
+	}
+}
+
+func TestRunConsumerConcurrency(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 4)
+	w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+
+	ack := &fakeAcknowledger{}
+	msgs := make(chan amqp.Delivery, 4)
+	for i := 1; i <= 4; i++ {
+		msgs <- amqp.Delivery{
+			Acknowledger: ack,
+			DeliveryTag:  uint64(i),
+			Body:         []byte(`{"messages":[{"recipient":"79218897127","body":"test"}]}`),
+		}
+	}
+	close(msgs)
+
+	var wg sync.WaitGroup
+	for i := 0; i < w.config.RabbitMQ.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.runConsumer(context.Background(), msgs)
+		}()
+	}
+
+	// All deliveries must be in flight at the same time
+	for i := 0; i < 4; i++ {
+		<-started
+	}
+	close(release)
+	wg.Wait()
+
+	if len(ack.acks) != 4 {
+		t.Errorf("expected 4 acks, got %d", len(ack.acks))
+	}
+}
+
+// fakeConnection is a connection that is never closed by the broker
+type fakeConnection struct{}
+
+func (fakeConnection) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error { return receiver }
+func (fakeConnection) Close() error                                           { return nil }
+
+// fakeChannel hands out deliveries and closes them on basic.cancel like the
+// server does
+type fakeChannel struct {
+	deliveries chan amqp.Delivery
+	cancelled  chan string
+}
+
+func newFakeChannel(deliveries ...amqp.Delivery) *fakeChannel {
+	ch := &fakeChannel{
+		deliveries: make(chan amqp.Delivery, len(deliveries)),
+		cancelled:  make(chan string, 1),
+	}
+	for _, d := range deliveries {
+		ch.deliveries <- d
+	}
+	return ch
+}
+
+func (f *fakeChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	return f.deliveries, nil
+}
+
+func (f *fakeChannel) Cancel(consumer string, noWait bool) error {
+	f.cancelled <- consumer
+	close(f.deliveries)
+	return nil
+}
+
+func (f *fakeChannel) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error { return receiver }
+func (f *fakeChannel) Close() error                                           { return nil }
+
+// startConsume runs consume with the fake channel until the returned cancel
+// is called, and returns the channel consume's error is sent to
+func startConsume(w *Worker, ch *fakeChannel) (context.CancelFunc, <-chan error) {
+	w.conn = fakeConnection{}
+	w.channel = ch
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- w.consume(ctx)
+	}()
+	return cancel, errs
+}
+
+func TestConsumeDrainsOnCancel(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+	w.config.RabbitMQ.ShutdownTimeout = time.Minute
+
+	ack := &fakeAcknowledger{}
+	ch := newFakeChannel(amqp.Delivery{
+		Acknowledger: ack,
+		DeliveryTag:  1,
+		Body:         []byte(`{"messages":[{"recipient":"79218897127","body":"test"}]}`),
+	})
+	cancel, errs := startConsume(w, ch)
+
+	<-started
+	cancel()
+	if tag := <-ch.cancelled; tag != w.consumerTag {
+		t.Errorf("expected consumer '%s' to be cancelled, got '%s'", w.consumerTag, tag)
+	}
+
+	// The in-flight send is waited for
+	select {
+	case err := <-errs:
+		t.Fatalf("expected consume to wait for the in-flight delivery, returned %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context canceled, got %v", err)
+	}
+	if len(ack.acks) != 1 {
+		t.Errorf("expected delivery to be acked, got %v", ack.acks)
+	}
+	if pub := w.publisher.(*fakePublisher); len(pub.published) != 0 {
+		t.Errorf("expected drained message not to be retried, got %v", pub.published)
+	}
+}
+
+func TestConsumeShutdownTimeout(t *testing.T) {
+	started := make(chan struct{}, 1)
+	w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-r.Context().Done()
+	})
+	w.config.RabbitMQ.ShutdownTimeout = 50 * time.Millisecond
+
+	ack := &fakeAcknowledger{}
+	ch := newFakeChannel(amqp.Delivery{
+		Acknowledger: ack,
+		DeliveryTag:  1,
+		Body:         []byte(`{"messages":[{"recipient":"79218897127","body":"test"}]}`),
+	})
+	cancel, errs := startConsume(w, ch)
+
+	<-started
+	cancel()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected in-flight send to be aborted after the shutdown timeout")
+	}
+
+	// The aborted message is retried and the delivery acknowledged
+	if len(ack.acks) != 1 {
+		t.Errorf("expected delivery to be acked, got %v", ack.acks)
+	}
+	pub := w.publisher.(*fakePublisher)
+	if len(pub.published) != 1 || pub.published[0].key != "sms.retry.1" {
+		t.Errorf("expected aborted message to be retried, got %v", pub.published)
+	}
+}
+
+func TestHandleDeliveryPartialFailure(t *testing.T) {
+	w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("clientId") {
+		case "invalid":
+			w.WriteHeader(http.StatusBadRequest)
+		case "79210000002":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	})
+
+	ack := &fakeAcknowledger{}
+	w.handleDelivery(context.Background(), amqp.Delivery{
+		Acknowledger: ack,
+		DeliveryTag:  1,
+		MessageId:    "req-1",
+		Body: []byte(`{"messages":[
+			{"recipient":"79210000001","body":"one"},
+			{"recipient":"invalid","body":"two"},
+			{"recipient":"79210000002","body":"three"}
+		]}`),
+	})
+
+	if len(ack.acks) != 1 {
+		t.Fatalf("expected delivery to be acked, got acks %v nacks %v", ack.acks, ack.nacks)
+	}
+
+	pub := w.publisher.(*fakePublisher)
+	if len(pub.published) != 2 {
+		t.Fatalf("expected 2 republished items, got %d", len(pub.published))
+	}
+
+	expected := []struct {
+		key       string
+		id        string
+		recipient string
+	}{
+		{"sms.dead", "req-1.1", "invalid"},
+		{"sms.retry.1", "req-1.2", "79210000002"},
+	}
+	for i, e := range expected {
+		p := pub.published[i]
+		if p.key != e.key {
+			t.Errorf("item %d: expected routing key '%s', got '%s'", i, e.key, p.key)
+		}
+		if p.msg.MessageId != e.id {
+			t.Errorf("item %d: expected message id '%s', got '%s'", i, e.id, p.msg.MessageId)
+		}
+
+		var req MessageRequest
+		if err := json.Unmarshal(p.msg.Body, &req); err != nil {
+			t.Fatalf("item %d: failed to unmarshal body: %v", i, err)
+		}
+		if len(req.Messages) != 1 || req.Messages[0].Recipient != e.recipient {
+			t.Errorf("item %d: expected only recipient '%s', got %+v", i, e.recipient, req.Messages)
+		}
+	}
+}
+
+func TestHandleDeliveryPartialFailureTemplate(t *testing.T) {
+	w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("clientId") == "79210000002" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	w.config.Templates = config.TemplatesConfig{
+		DefaultLocale: "ru",
+		Items:         map[string]map[string]string{"otp": {"ru": "Код: {{.code}}"}},
+	}
+	w.templates = templates.New(&w.config.Templates)
+	if err := w.templates.Reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	w.handleDelivery(context.Background(), amqp.Delivery{
+		Acknowledger: &fakeAcknowledger{},
+		MessageId:    "req-1",
+		Body: []byte(`{"messages":[
+			{"recipient":"79210000001","body":"one"},
+			{"recipient":"79210000002","template":"otp","params":{"code":"2652"}}
+		]}`),
+	})
+
+	pub := w.publisher.(*fakePublisher)
+	if len(pub.published) != 1 || pub.published[0].key != "sms.retry.1" {
+		t.Fatalf("expected the template item to be retried, got %+v", pub.published)
+	}
+
+	// The retry copy must pass the schema when it comes back
+	retried := amqp.Delivery{
+		ContentType: pub.published[0].msg.ContentType,
+		Headers:     pub.published[0].msg.Headers,
+		Body:        pub.published[0].msg.Body,
+	}
+	req, err := w.codecs[ContentTypeJSON].Decode(retried)
+	if err != nil {
+		t.Fatalf("expected retry copy to pass the schema, got %v", err)
+	}
+	if len(req.Messages) != 1 || req.Messages[0].Template != "otp" || req.Messages[0].Params["code"] != "2652" {
+		t.Errorf("expected only the template message, got %+v", req.Messages)
+	}
+}
+
+func TestHandleDeliveryMessageDeadline(t *testing.T) {
+	release := make(chan struct{})
+	w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+	defer close(release)
+	w.config.API.MessageDeadline = 50 * time.Millisecond
+
+	ack := &fakeAcknowledger{}
+	w.handleDelivery(context.Background(), amqp.Delivery{
+		Acknowledger: ack,
+		Body:         []byte(`{"messages":[{"recipient":"79218897127","body":"test"}]}`),
+	})
+
+	pub := w.publisher.(*fakePublisher)
+	if len(pub.published) != 1 || pub.published[0].key != "sms.retry.1" {
+		t.Errorf("expected timed out message to be retried, got %+v", pub.published)
+	}
+	if len(ack.acks) != 1 {
+		t.Errorf("expected delivery to be acked, got %v", ack.acks)
+	}
+}
+
+func TestHandleDeliveryRecipientLimit(t *testing.T) {
+	tests := []struct {
+		policy string
+		key    string
+	}{
+		{ratelimit.PolicyDelay, "sms.retry.2"},
+		{ratelimit.PolicyReject, "sms.dead"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			w.limiter = ratelimit.New(&config.RateLimitConfig{
+				RecipientMax:    1,
+				RecipientWindow: 10 * time.Minute,
+				Policy:          test.policy,
+			})
+
+			ack := &fakeAcknowledger{}
+			body := []byte(`{"messages":[{"recipient":"79218897127","body":"test"}]}`)
+			w.handleDelivery(context.Background(), amqp.Delivery{Acknowledger: ack, Body: body})
+			w.handleDelivery(context.Background(), amqp.Delivery{Acknowledger: ack, Body: body})
+
+			pub := w.publisher.(*fakePublisher)
+			if len(pub.published) != 1 || pub.published[0].key != test.key {
+				t.Errorf("expected throttled message to go to %s, got %+v", test.key, pub.published)
+			}
+		})
+	}
+}
+
+func TestHandleDeliveryFailedSendKeepsRecipientQuota(t *testing.T) {
+	var sent int
+	w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		sent++
+		if sent == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	w.limiter = ratelimit.New(&config.RateLimitConfig{
+		RecipientMax:    1,
+		RecipientWindow: 10 * time.Minute,
+	})
+
+	ack := &fakeAcknowledger{}
+	body := []byte(`{"messages":[{"recipient":"79218897127","body":"test"}]}`)
+	w.handleDelivery(context.Background(), amqp.Delivery{Acknowledger: ack, Body: body})
+	w.handleDelivery(context.Background(), amqp.Delivery{Acknowledger: ack, Body: body})
+
+	if sent != 2 {
+		t.Errorf("expected the failed send not to use up the quota, got %d sends", sent)
+	}
+}
+
+func TestHandleDeliveryNormalizesRecipient(t *testing.T) {
+	var clientIDs []string
+	w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		clientIDs = append(clientIDs, r.URL.Query().Get("clientId"))
+		w.WriteHeader(http.StatusOK)
+	})
+
+	ack := &fakeAcknowledger{}
+	w.handleDelivery(context.Background(), amqp.Delivery{
+		Acknowledger: ack,
+		Body: []byte(`{"messages":[
+			{"recipient":"+7 (921) 889-71-27","body":"one"},
+			{"recipient":"garbage","body":"two"}
+		]}`),
+	})
+
+	if len(clientIDs) != 1 || clientIDs[0] != "79218897127" {
+		t.Errorf("expected only the normalized number to be sent, got %v", clientIDs)
+	}
+
+	pub := w.publisher.(*fakePublisher)
+	if len(pub.published) != 1 {
+		t.Fatalf("expected invalid recipient to be dead-lettered, got %+v", pub.published)
+	}
+	p := pub.published[0]
+	if p.key != "sms.dead" || p.msg.Headers[deadReasonHeader] != reasonRecipient {
+		t.Errorf("expected dead-letter with reason '%s', got %s %v", reasonRecipient, p.key, p.msg.Headers)
+	}
+}
+
+func TestHandleDeliveryMaxSegments(t *testing.T) {
+	var sent int
+	w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		sent++
+		w.WriteHeader(http.StatusOK)
+	})
+	w.config.SMS.MaxSegments = 2
+
+	body, _ := json.Marshal(MessageRequest{Messages: []Message{
+		{Recipient: "79218897127", Body: strings.Repeat("код ", 30)},
+		{Recipient: "79218897127", Body: strings.Repeat("код ", 40)},
+	}})
+
+	ack := &fakeAcknowledger{}
+	w.handleDelivery(context.Background(), amqp.Delivery{Acknowledger: ack, Body: body})
+
+	if sent != 1 {
+		t.Errorf("expected only the message within the limit to be sent, got %d", sent)
+	}
+
+	pub := w.publisher.(*fakePublisher)
+	if len(pub.published) != 1 {
+		t.Fatalf("expected long message to be dead-lettered, got %+v", pub.published)
+	}
+	p := pub.published[0]
+	if p.key != "sms.dead" || p.msg.Headers[deadReasonHeader] != reasonTooLong {
+		t.Errorf("expected dead-letter with reason '%s', got %s %v", reasonTooLong, p.key, p.msg.Headers)
+	}
+}
+
+func TestHandleDeliveryTransliteration(t *testing.T) {
+	var messages []string
+	w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		messages = append(messages, r.URL.Query().Get("message"))
+		w.WriteHeader(http.StatusOK)
+	})
+	w.config.SMS.Transliterate = "never"
+	w.config.SMS.TransliterateSources = map[string]string{"alarm": "always"}
+
+	send := func(appID, body string) {
+		w.handleDelivery(context.Background(), amqp.Delivery{
+			Acknowledger: &fakeAcknowledger{},
+			AppId:        appID,
+			Body:         []byte(body),
+		})
+	}
+	send("", `{"messages":[{"recipient":"79218897127","body":"Код: 2652"}]}`)
+	send("alarm", `{"messages":[{"recipient":"79218897127","body":"Код: 2652"}]}`)
+	send("alarm", `{"messages":[{"recipient":"79218897127","body":"Код: 2652","transliterate":"never"}]}`)
+
+	expected := []string{"Код: 2652", "Kod: 2652", "Код: 2652"}
+	if len(messages) != len(expected) {
+		t.Fatalf("expected %d messages, got %v", len(expected), messages)
+	}
+	for i := range expected {
+		if messages[i] != expected[i] {
+			t.Errorf("message %d: expected %q, got %q", i, expected[i], messages[i])
+		}
+	}
+}
+
+func TestHandleDeliveryTemplate(t *testing.T) {
+	var messages []string
+	w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		messages = append(messages, r.URL.Query().Get("message"))
+		w.WriteHeader(http.StatusOK)
+	})
+	w.config.Templates = config.TemplatesConfig{
+		DefaultLocale: "ru",
+		Items: map[string]map[string]string{
+			"otp": {"ru": "Код: {{.code}}", "en": "Code: {{.code}}"},
+		},
+	}
+	w.templates = templates.New(&w.config.Templates)
+	if err := w.templates.Reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	w.handleDelivery(context.Background(), amqp.Delivery{
+		Acknowledger: &fakeAcknowledger{},
+		Body: []byte(`{"messages":[
+			{"recipient":"79218897127","template":"otp","locale":"en","params":{"code":"2652"}},
+			{"recipient":"79218897127","template":"otp","params":{}}
+		]}`),
+	})
+
+	if len(messages) != 1 || messages[0] != "Code: 2652" {
+		t.Errorf("expected rendered template to be sent, got %v", messages)
+	}
+
+	pub := w.publisher.(*fakePublisher)
+	if len(pub.published) != 1 {
+		t.Fatalf("expected message with missing params to be dead-lettered, got %+v", pub.published)
+	}
+	p := pub.published[0]
+	if p.key != "sms.dead" || p.msg.Headers[deadReasonHeader] != reasonTemplate {
+		t.Errorf("expected dead-letter with reason '%s', got %s %v", reasonTemplate, p.key, p.msg.Headers)
+	}
+}
+
+func TestHandleDeliveryExtendedFields(t *testing.T) {
+	var sources []string
+	w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		sources = append(sources, r.URL.Query().Get("source"))
+		w.WriteHeader(http.StatusOK)
+	})
+	w.config.API.Source = "StarLine"
+
+	ack := &fakeAcknowledger{}
+	w.handleDelivery(context.Background(), amqp.Delivery{
+		Acknowledger: ack,
+		Timestamp:    time.Now().Add(-time.Hour),
+		Body: []byte(`{"messages":[
+			{"recipient":"79218897127","body":"one"},
+			{"recipient":"79218897127","body":"two","sender":"StarLine-M"},
+			{"recipient":"79218897127","body":"three","ttl":60},
+			{"recipient":"79218897127","body":"four","source":"StarLine","sender":"Other"}
+		]}`),
+	})
+
+	expected := []string{"StarLine", "StarLine-M"}
+	if len(sources) != len(expected) || sources[0] != expected[0] || sources[1] != expected[1] {
+		t.Errorf("expected sources %v, got %v", expected, sources)
+	}
+
+	pub := w.publisher.(*fakePublisher)
+	if len(pub.published) != 1 {
+		t.Fatalf("expected only the invalid message to be dead-lettered, got %+v", pub.published)
+	}
+	p := pub.published[0]
+	if p.key != "sms.dead" || p.msg.Headers[deadReasonHeader] != reasonInvalid {
+		t.Errorf("expected dead-letter with reason '%s', got %s %v", reasonInvalid, p.key, p.msg.Headers)
+	}
+	if len(ack.acks) != 1 {
+		t.Errorf("expected delivery to be acked, got %v", ack.acks)
+	}
+}
+
+func TestHandleDeliveryInvalidPayload(t *testing.T) {
+	var sent int
+	w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		sent++
+		w.WriteHeader(http.StatusOK)
+	})
+
+	ack := &fakeAcknowledger{}
+	w.handleDelivery(context.Background(), amqp.Delivery{
+		Acknowledger: ack,
+		Headers:      amqp.Table{"x-trace": "abc"},
+		Body:         []byte(`{"messages":[{"recipient":"","body":"test","colour":"red"}]}`),
+	})
+
+	if sent != 0 {
+		t.Errorf("expected invalid payload not to be sent, got %d sends", sent)
+	}
+	if len(ack.acks) != 1 {
+		t.Errorf("expected delivery to be acked, got %v", ack.acks)
+	}
+
+	pub := w.publisher.(*fakePublisher)
+	if len(pub.published) != 1 {
+		t.Fatalf("expected invalid payload to be published once, got %+v", pub.published)
+	}
+	p := pub.published[0]
+	if p.key != "sms.invalid" {
+		t.Errorf("expected invalid queue, got %s", p.key)
+	}
+	if p.msg.Headers[invalidReasonHeader] != "schema" || p.msg.Headers[schemaVersionHeader] != "1" {
+		t.Errorf("unexpected headers %v", p.msg.Headers)
+	}
+	if errs, _ := p.msg.Headers[validationErrorsHeader].([]interface{}); len(errs) != 2 {
+		t.Errorf("expected 2 validation errors, got %v", p.msg.Headers[validationErrorsHeader])
+	}
+	if p.msg.Headers["x-trace"] != "abc" {
+		t.Errorf("expected original headers to be kept, got %v", p.msg.Headers)
+	}
+}
+
+func TestHandleDeliveryTracksProviderMessageID(t *testing.T) {
+	w := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "OK: 12345")
+	})
+
+	ack := &fakeAcknowledger{}
+	w.handleDelivery(context.Background(), amqp.Delivery{
+		Acknowledger:  ack,
+		MessageId:     "msg-1",
+		CorrelationId: "corr-1",
+		Body:          []byte(`{"messages":[{"id":"a1","recipient":"79218897127","body":"test"}]}`),
+	})
+
+	rec, ok, _ := w.reports.Get("12345")
+	if !ok {
+		t.Fatal("expected sent message to be tracked")
+	}
+	if rec.Status != dlr.StatusSent || rec.MessageID != "msg-1" || rec.CorrelationID != "corr-1" || rec.ID != "a1" {
+		t.Errorf("unexpected record %+v", rec)
+	}
+}